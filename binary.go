@@ -0,0 +1,234 @@
+package safesession
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+	"time"
+)
+
+// binaryVersion 是 [Session] 二进制编码格式的版本，
+// 必须小于0x20，以便与旧格式区分，参见 [isLegacy] 。
+//
+// 编码格式为1字节的版本号，然后按字段声明顺序依次是各字段的值：
+//
+// string编码为uvarint的长度和内容
+//
+// int64编码为varint
+//
+// float64编码为8字节小端序的IEEE 754表示
+//
+// time.Time编码为varint的Unix纳秒时间戳，零值编码为math.MinInt64
+//
+// map[string]string编码为uvarint的长度，然后是按键排序的键和值
+//
+// 版本2增加了Claims字段，版本3增加了IssuedAt字段，版本4增加了RotatedAt字段，
+// 版本5增加了LocatedAt字段。
+// 解码时兼容旧版本，旧版本没有的字段为零值。
+const binaryVersion = 5
+
+var errShortBinary = errors.New("会话编码数据不完整")
+
+// zeroTime 是time.Time零值的编码。
+const zeroTime = math.MinInt64
+
+// MarshalBinary 实现 [encoding.BinaryMarshaler] 。
+func (s *Session) MarshalBinary() ([]byte, error) {
+	return s.AppendBinary(make([]byte, 0, 256))
+}
+
+// AppendBinary 实现 [encoding.BinaryAppender] 。
+func (s *Session) AppendBinary(b []byte) ([]byte, error) {
+	b = append(b, binaryVersion)
+	b = appendString(b, s.ID)
+	b = appendTime(b, s.CreateTime)
+	b = appendString(b, s.Ip.Country)
+	b = appendString(b, s.Ip.Region)
+	b = appendString(b, s.Ip.City)
+	b = appendString(b, s.Ip.ISP)
+	b = appendFloat(b, s.Ip.Longitude)
+	b = appendFloat(b, s.Ip.Latitude)
+	b = binary.AppendVarint(b, s.Ip.AS)
+	b = appendFloat(b, s.Gps.Longitude)
+	b = appendFloat(b, s.Gps.Latitude)
+	b = appendString(b, s.CSRF_TOKEN)
+	b = appendString(b, s.Os)
+	b = appendString(b, s.OsVersion)
+	b = appendString(b, s.Name)
+	b = appendString(b, s.Device)
+	b = appendString(b, s.Broswer)
+	b = binary.AppendVarint(b, s.Screen.Width)
+	b = binary.AppendVarint(b, s.Screen.Height)
+	b = binary.AppendVarint(b, s.PNum)
+	if s.claims == "" {
+		b = appendMap(b, nil)
+	} else {
+		b = append(b, s.claims...)
+	}
+	b = appendTime(b, s.IssuedAt)
+	b = appendTime(b, s.RotatedAt)
+	b = appendTime(b, s.LocatedAt)
+	return b, nil
+}
+
+// UnmarshalBinary 实现 [encoding.BinaryUnmarshaler] 。
+func (s *Session) UnmarshalBinary(b []byte) error {
+	if len(b) == 0 {
+		return errShortBinary
+	}
+	v := b[0]
+	if v == 0 || v > binaryVersion {
+		return fmt.Errorf("未知的会话编码版本 %d", v)
+	}
+	d := decoder{b: b[1:]}
+	var n Session
+	n.ID = d.string()
+	n.CreateTime = d.time()
+	n.Ip.Country = d.string()
+	n.Ip.Region = d.string()
+	n.Ip.City = d.string()
+	n.Ip.ISP = d.string()
+	n.Ip.Longitude = d.float()
+	n.Ip.Latitude = d.float()
+	n.Ip.AS = d.int()
+	n.Gps.Longitude = d.float()
+	n.Gps.Latitude = d.float()
+	n.CSRF_TOKEN = d.string()
+	n.Os = d.string()
+	n.OsVersion = d.string()
+	n.Name = d.string()
+	n.Device = d.string()
+	n.Broswer = d.string()
+	n.Screen.Width = d.int()
+	n.Screen.Height = d.int()
+	n.PNum = d.int()
+	if v >= 2 {
+		n.claims = encodeClaims(d.stringMap())
+	}
+	if v >= 3 {
+		n.IssuedAt = d.time()
+	}
+	if v >= 4 {
+		n.RotatedAt = d.time()
+	}
+	if v >= 5 {
+		n.LocatedAt = d.time()
+	}
+	if d.err != nil {
+		return d.err
+	}
+	if len(d.b) != 0 {
+		return fmt.Errorf("会话编码数据末尾有%d字节多余数据", len(d.b))
+	}
+	*s = n
+	return nil
+}
+
+func appendString(b []byte, s string) []byte {
+	b = binary.AppendUvarint(b, uint64(len(s)))
+	return append(b, s...)
+}
+
+func appendFloat(b []byte, f float64) []byte {
+	return binary.LittleEndian.AppendUint64(b, math.Float64bits(f))
+}
+
+func appendTime(b []byte, t time.Time) []byte {
+	if t.IsZero() {
+		return binary.AppendVarint(b, zeroTime)
+	}
+	return binary.AppendVarint(b, t.UnixNano())
+}
+
+func appendMap(b []byte, m map[string]string) []byte {
+	b = binary.AppendUvarint(b, uint64(len(m)))
+	for _, k := range slices.Sorted(maps.Keys(m)) {
+		b = appendString(b, k)
+		b = appendString(b, m[k])
+	}
+	return b
+}
+
+// decoder 解码二进制数据，
+// 遇到第一个错误后，之后的解码都返回零值。
+type decoder struct {
+	b   []byte
+	err error
+}
+
+func (d *decoder) string() string {
+	n, l := binary.Uvarint(d.b)
+	if d.err != nil || l <= 0 || n > uint64(len(d.b)-l) {
+		d.fail()
+		return ""
+	}
+	s := string(d.b[l : l+int(n)])
+	d.b = d.b[l+int(n):]
+	return s
+}
+
+func (d *decoder) int() int64 {
+	v, l := binary.Varint(d.b)
+	if d.err != nil || l <= 0 {
+		d.fail()
+		return 0
+	}
+	d.b = d.b[l:]
+	return v
+}
+
+func (d *decoder) float() float64 {
+	if d.err != nil || len(d.b) < 8 {
+		d.fail()
+		return 0
+	}
+	v := math.Float64frombits(binary.LittleEndian.Uint64(d.b))
+	d.b = d.b[8:]
+	return v
+}
+
+func (d *decoder) time() time.Time {
+	v := d.int()
+	if v == zeroTime {
+		return time.Time{}
+	}
+	return time.Unix(0, v)
+}
+
+// stringMap 解码map[string]string，长度为0时返回nil。
+// 键必须是递增的，使每个map只有一种编码。
+func (d *decoder) stringMap() map[string]string {
+	n, l := binary.Uvarint(d.b)
+	// 每个键值对至少占用2字节。
+	if d.err != nil || l <= 0 || n > uint64(len(d.b)-l)/2 {
+		d.fail()
+		return nil
+	}
+	d.b = d.b[l:]
+	if n == 0 {
+		return nil
+	}
+	m := make(map[string]string, n)
+	prev := ""
+	for i := range n {
+		k := d.string()
+		if i != 0 && k <= prev {
+			d.fail()
+		}
+		m[k] = d.string()
+		prev = k
+	}
+	if d.err != nil {
+		return nil
+	}
+	return m
+}
+
+func (d *decoder) fail() {
+	if d.err == nil {
+		d.err = errShortBinary
+	}
+}
@@ -0,0 +1,145 @@
+package safesession
+
+import (
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/qiulaidongfeng/safesession/v3/codec"
+)
+
+var testSession = Session{
+	ID:         "mXqG0Y7YyJb4h0r1i3cQ8l0w5fT2eK3p9sV6uA1dZ0E=",
+	CreateTime: time.Unix(0, time.Now().UnixNano()),
+	Ip:         IPInfo{Country: "CN", Region: "Shanghai", City: "Shanghai", ISP: "China Unicom", Longitude: 121.47, Latitude: 31.23, AS: 4837},
+	Gps:        GpsInfo{Longitude: math.MaxFloat64, Latitude: math.MaxFloat64},
+	CSRF_TOKEN: "a\x00b",
+	Os:         "Android",
+	OsVersion:  "6.0",
+	Name:       "user",
+	Device:     "fingerprint",
+	Broswer:    "Chrome",
+	Screen:     Screen{Width: 1920, Height: 1080},
+	PNum:       -1,
+	claims:     encodeClaims(map[string]string{"tenant": "1", "roles": "admin,user", "": "\x00"}),
+	IssuedAt:   time.Unix(0, time.Now().Add(-time.Hour).UnixNano()),
+	RotatedAt:  time.Unix(0, time.Now().Add(-time.Minute).UnixNano()),
+	LocatedAt:  time.Unix(0, time.Now().Add(-time.Second).UnixNano()),
+}
+
+func TestBinary(t *testing.T) {
+	b, err := testSession.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var s Session
+	if err := s.UnmarshalBinary(b); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(s, testSession) {
+		t.Fatalf("%+v != %+v", s, testSession)
+	}
+	if l := len(codec.Encode(&testSession)); len(b) >= l {
+		t.Fatalf("binary %d bytes should be smaller than legacy %d bytes", len(b), l)
+	}
+	for i := range b {
+		if err := s.UnmarshalBinary(b[:i]); err == nil {
+			t.Fatalf("truncated to %d bytes should fail", i)
+		}
+	}
+	if err := s.UnmarshalBinary(append(b, 0)); err == nil {
+		t.Fatal("trailing data should fail")
+	}
+	b[0] = 0xff
+	if err := s.UnmarshalBinary(b); err == nil {
+		t.Fatal("unknown version should fail")
+	}
+}
+
+func TestBinaryOldVersion(t *testing.T) {
+	zero := len(appendTime(nil, time.Time{}))
+	s := testSession
+	// 旧版本没有的字段在末尾，编码为零值后截去。
+	s.LocatedAt = time.Time{}
+	check := func(v byte, cut int) {
+		t.Helper()
+		b, _ := s.MarshalBinary()
+		b = b[:len(b)-cut]
+		b[0] = v
+		var s2 Session
+		if err := s2.UnmarshalBinary(b); err != nil || !reflect.DeepEqual(s, s2) {
+			t.Fatalf("version %d: %v %+v", v, err, s2)
+		}
+	}
+	check(4, zero)
+	s.RotatedAt = time.Time{}
+	check(3, 2*zero)
+	s.IssuedAt = time.Time{}
+	check(2, 3*zero)
+	// 版本1也没有Claims，IssuedAt前面是Claims的长度0。
+	s.claims = ""
+	check(1, 3*zero+1)
+}
+
+func TestBinaryZeroTime(t *testing.T) {
+	var s, s2 Session
+	b, _ := s.MarshalBinary()
+	if err := s2.UnmarshalBinary(b); err != nil || !s2.CreateTime.IsZero() {
+		t.Fatal(s2.CreateTime, err)
+	}
+}
+
+func FuzzBinary(f *testing.F) {
+	b, _ := testSession.MarshalBinary()
+	f.Add(b)
+	f.Fuzz(func(t *testing.T, b []byte) {
+		var s Session
+		if s.UnmarshalBinary(b) != nil {
+			return
+		}
+		b2, err := s.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var s2 Session
+		if err := s2.UnmarshalBinary(b2); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(s.encode(), s2.encode()) {
+			t.Fatalf("%+v != %+v", s, s2)
+		}
+	})
+}
+
+func BenchmarkEncode(b *testing.B) {
+	b.Run("binary", func(b *testing.B) {
+		buf := make([]byte, 0, 256)
+		for b.Loop() {
+			buf, _ = testSession.AppendBinary(buf[:0])
+		}
+	})
+	b.Run("codec", func(b *testing.B) {
+		for b.Loop() {
+			codec.Encode(&testSession)
+		}
+	})
+}
+
+func BenchmarkDecode(b *testing.B) {
+	b.Run("binary", func(b *testing.B) {
+		v, _ := testSession.MarshalBinary()
+		var s Session
+		for b.Loop() {
+			s.UnmarshalBinary(v)
+		}
+	})
+	b.Run("codec", func(b *testing.B) {
+		s := testSession
+		s.CSRF_TOKEN = ""
+		v := codec.Encode(&s)
+		for b.Loop() {
+			codec.Decode(&s, v)
+		}
+	})
+}
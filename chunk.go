@@ -0,0 +1,110 @@
+package safesession
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+var ErrCookieTooLarge = errors.New("登录会话cookie过大")
+
+// cookieChunkSize 是每个cookie的值的最大长度，
+// 留出cookie name和属性的空间，使每个cookie都小于浏览器限制的4KB。
+const cookieChunkSize = 3800
+
+// chunkPrefix 是分块保存时，名为name的cookie的值的前缀，后面是块数。
+// 不分块时cookie的值以密钥ID，base32或base64url编码开头，不会以它开头。
+const chunkPrefix = "~"
+
+// maxCookieSize 返回保存 [Session] 的所有cookie的值的总长度上限。
+func (c *Control) maxCookieSize() int {
+	if c.MaxCookieSize > 0 {
+		return c.MaxCookieSize
+	}
+	return cookieChunkSize
+}
+
+// maxChunks 返回最多分为多少块，为1时不分块。
+func (c *Control) maxChunks() int {
+	return (c.maxCookieSize() + cookieChunkSize - 1) / cookieChunkSize
+}
+
+// chunkName 返回第i块的cookie name。
+func chunkName(name string, i int) string {
+	return name + "." + strconv.Itoa(i)
+}
+
+// newCookie 返回保存 [Session] 的cookie。
+func (c *Control) newCookie(name, value string, maxAge int) *http.Cookie {
+	return &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     c.cookiePath(),
+		Domain:   c.cookieDomain(),
+		SameSite: c.sameSite,
+		Secure:   true,
+		HttpOnly: true,
+		MaxAge:   maxAge,
+	}
+}
+
+// setCookies 响应名为name，值为v的cookie。
+// v过长时分块保存到name.0，name.1……，名为name的cookie保存块数，
+// 并删除之前响应的多余的块。
+func (c *Control) setCookies(w http.ResponseWriter, name, v string) error {
+	if len(v) > c.maxCookieSize() {
+		return ErrCookieTooLarge
+	}
+	maxAge := int(c.sessionMaxAge.Seconds())
+	n := 0
+	if len(v) > cookieChunkSize {
+		n = (len(v) + cookieChunkSize - 1) / cookieChunkSize
+		http.SetCookie(w, c.newCookie(name, chunkPrefix+strconv.Itoa(n), maxAge))
+		for i := range n {
+			end := min((i+1)*cookieChunkSize, len(v))
+			http.SetCookie(w, c.newCookie(chunkName(name, i), v[i*cookieChunkSize:end], maxAge))
+		}
+	} else {
+		http.SetCookie(w, c.newCookie(name, v, maxAge))
+	}
+	c.clearChunks(w, name, n)
+	return nil
+}
+
+// clearChunks 删除从第from块开始的所有块。
+// 不知道客户端有多少块，所以删除可能存在的所有块。
+func (c *Control) clearChunks(w http.ResponseWriter, name string, from int) {
+	if c.maxChunks() == 1 {
+		return
+	}
+	for i := from; i < c.maxChunks(); i++ {
+		http.SetCookie(w, c.newCookie(chunkName(name, i), "", -1))
+	}
+}
+
+// readCookie 从请求读取保存 [Session] 的cookie，
+// 如果分块保存，合并所有块。
+func (c *Control) readCookie(r *http.Request) (*http.Cookie, error) {
+	cookie, err := r.Cookie(c.cookieName(&Session{}))
+	if err != nil {
+		return nil, err
+	}
+	v, ok := strings.CutPrefix(cookie.Value, chunkPrefix)
+	if !ok {
+		return cookie, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n <= 0 || n > c.maxChunks() {
+		return nil, http.ErrNoCookie
+	}
+	var buf strings.Builder
+	for i := range n {
+		chunk, err := r.Cookie(chunkName(cookie.Name, i))
+		if err != nil {
+			return nil, err
+		}
+		buf.WriteString(chunk.Value)
+	}
+	return &http.Cookie{Name: cookie.Name, Value: buf.String()}, nil
+}
@@ -0,0 +1,76 @@
+package safesession
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestChunk(t *testing.T) {
+	c.MaxClaimsSize = 3 * cookieChunkSize
+	defer func() { c.MaxClaimsSize, c.MaxCookieSize = 0, 0 }()
+	s := c.NewSession("192.168.0.3", user_agent, "ok")
+	s.SetClaim("big", strings.Repeat("a", cookieChunkSize))
+	w := httptest.NewRecorder()
+	if err := c.SetSession(&s, w); err != ErrCookieTooLarge {
+		t.Fatal(err)
+	}
+	if len(w.Result().Cookies()) != 0 {
+		t.Fatal("should not set cookie")
+	}
+
+	c.MaxCookieSize = 4 * cookieChunkSize
+	check := func(cs []*http.Cookie) Session {
+		t.Helper()
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = "192.168.0.3:1234"
+		r.Header.Set("User-Agent", user_agent)
+		for _, v := range cs {
+			if v.MaxAge >= 0 {
+				r.AddCookie(v)
+			}
+		}
+		ok, err, se := c.CheckLoginedFromRequest(r)
+		if !ok || err != nil {
+			t.Fatal(ok, err)
+		}
+		return se
+	}
+	w = httptest.NewRecorder()
+	if err := c.SetSession(&s, w); err != nil {
+		t.Fatal(err)
+	}
+	cs := w.Result().Cookies()
+	// base32编码后约为6000字节，分为两块，删除第3，4块。
+	if len(cs) != 5 || cs[0].Value != "~2" || cs[1].Name != "session.0" || len(cs[1].Value) != cookieChunkSize || cs[3].MaxAge != -1 || cs[4].Name != "session.3" {
+		t.Fatalf("%+v", cs)
+	}
+	if se := check(cs); se.claims != s.claims {
+		t.Fatal("claims mismatch")
+	}
+
+	s.DeleteClaim("big")
+	w = httptest.NewRecorder()
+	if err := c.SetSession(&s, w); err != nil {
+		t.Fatal(err)
+	}
+	cs = w.Result().Cookies()
+	if len(cs) != 5 || cs[0].Name != "session" || strings.HasPrefix(cs[0].Value, chunkPrefix) || cs[1].Name != "session.0" || cs[1].MaxAge != -1 {
+		t.Fatalf("should clear stale chunks, got %+v", cs)
+	}
+	check(cs)
+
+	w = httptest.NewRecorder()
+	c.ClearSession(w)
+	if cs := w.Result().Cookies(); len(cs) != 5 {
+		t.Fatalf("%+v", cs)
+	}
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.AddCookie(&http.Cookie{Name: "session", Value: "~2"})
+	r.AddCookie(&http.Cookie{Name: "session.0", Value: "A"})
+	if _, err := c.readCookie(r); err == nil {
+		t.Fatal("missing chunk should fail")
+	}
+}
@@ -0,0 +1,74 @@
+package safesession
+
+import "errors"
+
+var ErrClaimsTooLarge = errors.New("登录会话附加的数据过大")
+
+// defaultMaxClaimsSize 是 [Session.Claims] 默认的最大大小，
+// 加密和编码后，cookie仍然小于浏览器限制的4KB。
+const defaultMaxClaimsSize = 1024
+
+// Claims 返回调用者附加的数据的副本，比如租户ID，角色，没有时返回nil。
+// 它们和其他字段一起加密后保存在客户浏览器，
+// 通过检查后可以直接使用，不需要查询数据库。
+// 大小受 [Control.MaxClaimsSize] 限制。
+func (s *Session) Claims() map[string]string {
+	if s.claims == "" {
+		return nil
+	}
+	d := decoder{b: []byte(s.claims)}
+	return d.stringMap()
+}
+
+// Claim 返回 [Session.Claims] 中键为k的值。
+func (s *Session) Claim(k string) (string, bool) {
+	v, ok := s.Claims()[k]
+	return v, ok
+}
+
+// SetClaim 设置 [Session.Claims] 中键为k的值为v。
+// 需要调用 [Control.SetSession] 才会保存到cookie。
+func (s *Session) SetClaim(k, v string) {
+	m := s.Claims()
+	if m == nil {
+		m = make(map[string]string)
+	}
+	m[k] = v
+	s.claims = encodeClaims(m)
+}
+
+// DeleteClaim 删除 [Session.Claims] 中键为k的值。
+// 需要调用 [Control.SetSession] 才会保存到cookie。
+func (s *Session) DeleteClaim(k string) {
+	m := s.Claims()
+	delete(m, k)
+	s.claims = encodeClaims(m)
+}
+
+// encodeClaims 返回m的二进制编码，m为空时返回空字符串。
+func encodeClaims(m map[string]string) string {
+	if len(m) == 0 {
+		return ""
+	}
+	return string(appendMap(nil, m))
+}
+
+// maxClaimsSize 返回 [Session.Claims] 的最大大小。
+func (c *Control) maxClaimsSize() int {
+	if c.MaxClaimsSize > 0 {
+		return c.MaxClaimsSize
+	}
+	return defaultMaxClaimsSize
+}
+
+// checkClaims 检查 [Session.Claims] 所有键和值的总长度是否超过限制。
+func (c *Control) checkClaims(se *Session) error {
+	n := 0
+	for k, v := range se.Claims() {
+		n += len(k) + len(v)
+	}
+	if n > c.maxClaimsSize() {
+		return ErrClaimsTooLarge
+	}
+	return nil
+}
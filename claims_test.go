@@ -0,0 +1,49 @@
+package safesession
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestClaims(t *testing.T) {
+	defer func(n int) { delete_num = n }(delete_num)
+	s := c.NewSession("192.168.0.3", user_agent, "ok")
+	s.SetClaim("tenant", "1")
+	s.SetClaim("roles", "admin")
+	w := httptest.NewRecorder()
+	if err := c.SetSession(&s, w); err != nil {
+		t.Fatal(err)
+	}
+	ok, err, se := c.CheckLogined("192.168.0.3", user_agent, w.Result().Cookies()[0])
+	if !ok || err != nil {
+		t.Fatal(ok, err)
+	}
+	if v, _ := se.Claim("tenant"); v != "1" {
+		t.Fatalf("%+v", se.Claims())
+	}
+	if v, _ := se.Claim("roles"); v != "admin" {
+		t.Fatalf("%+v", se.Claims())
+	}
+	if _, ok := se.Claim("none"); ok {
+		t.Fatal("should not have claim none")
+	}
+
+	s.SetClaim("big", strings.Repeat("a", defaultMaxClaimsSize))
+	w = httptest.NewRecorder()
+	if err := c.SetSession(&s, w); err != ErrClaimsTooLarge {
+		t.Fatal(err)
+	}
+	if len(w.Result().Cookies()) != 0 {
+		t.Fatal("should not set cookie")
+	}
+	c.MaxClaimsSize = 2 * defaultMaxClaimsSize
+	defer func() { c.MaxClaimsSize = 0 }()
+	w = httptest.NewRecorder()
+	if err := c.SetSession(&s, w); err != nil {
+		t.Fatal(err)
+	}
+	if v := w.Result().Cookies()[0].Value; len(v) >= 4096 {
+		t.Fatalf("cookie is %d bytes", len(v))
+	}
+}
@@ -0,0 +1,157 @@
+package safesession
+
+import (
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// SetTrustedProxies 设置受信任的代理。
+// cidrs 可以是CIDR，比如"10.0.0.0/8"，也可以是单个ip。
+// 只有请求直接来自受信任的代理时， [Control.ClientIP] 才会使用X-Forwarded-For等请求标头。
+// 不能与 [Control.ClientIP] 并发调用。
+func (c *Control) SetTrustedProxies(cidrs ...string) error {
+	ps := make([]netip.Prefix, 0, len(cidrs))
+	for _, v := range cidrs {
+		v = strings.TrimSpace(v)
+		if !strings.Contains(v, "/") {
+			addr, err := netip.ParseAddr(v)
+			if err != nil {
+				return err
+			}
+			addr = addr.Unmap()
+			ps = append(ps, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		p, err := netip.ParsePrefix(v)
+		if err != nil {
+			return err
+		}
+		if p.Addr().Is4In6() {
+			p = netip.PrefixFrom(p.Addr().Unmap(), p.Bits()-96)
+		}
+		ps = append(ps, p.Masked())
+	}
+	c.trustedProxies = ps
+	return nil
+}
+
+// ClientIP 返回请求的客户端ip，不带端口号。
+// 从多个goroutine调用是安全的。
+//
+// 如果请求来自 [Control.SetTrustedProxies] 设置的受信任的代理，
+// 按顺序使用Forwarded，X-Forwarded-For，X-Real-IP请求标头中，
+// 从右往左第一个不受信任的ip。
+// IPv4映射的IPv6地址会转换为IPv4地址。
+func (c *Control) ClientIP(r *http.Request) string {
+	remote, ok := parseAddr(r.RemoteAddr)
+	if !ok {
+		return strings.TrimSpace(r.RemoteAddr)
+	}
+	if !c.trusted(remote) {
+		return remote.String()
+	}
+	if v := r.Header.Values("Forwarded"); len(v) != 0 {
+		if ip, ok := c.fromChain(forwardedFor(v)); ok {
+			return ip.String()
+		}
+	}
+	if v := r.Header.Values("X-Forwarded-For"); len(v) != 0 {
+		var chain []string
+		for _, s := range v {
+			chain = append(chain, strings.Split(s, ",")...)
+		}
+		if ip, ok := c.fromChain(chain); ok {
+			return ip.String()
+		}
+	}
+	if ip, ok := parseAddr(r.Header.Get("X-Real-IP")); ok {
+		return ip.String()
+	}
+	return remote.String()
+}
+
+// trusted 报告ip是否是受信任的代理。
+func (c *Control) trusted(ip netip.Addr) bool {
+	for _, p := range c.trustedProxies {
+		if p.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// fromChain 从右往左返回代理链中第一个不受信任的ip。
+// 如果都是受信任的，返回最左边的ip。
+// 如果有无法解析的ip，返回false。
+func (c *Control) fromChain(chain []string) (netip.Addr, bool) {
+	var ip netip.Addr
+	for i := len(chain) - 1; i >= 0; i-- {
+		var ok bool
+		ip, ok = parseAddr(chain[i])
+		if !ok {
+			return netip.Addr{}, false
+		}
+		if !c.trusted(ip) {
+			return ip, true
+		}
+	}
+	return ip, ip.IsValid()
+}
+
+// forwardedFor 返回RFC 7239 Forwarded请求标头中所有for参数的值。
+func forwardedFor(values []string) []string {
+	var ret []string
+	for _, v := range values {
+		for _, elem := range strings.Split(v, ",") {
+			for _, pair := range strings.Split(elem, ";") {
+				k, v, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if ok && strings.EqualFold(k, "for") {
+					ret = append(ret, v)
+				}
+			}
+		}
+	}
+	return ret
+}
+
+// parseAddr 解析可能带端口号、引号、方括号的ip。
+func parseAddr(s string) (netip.Addr, bool) {
+	s = strings.Trim(strings.TrimSpace(s), `"`)
+	if s == "" {
+		return netip.Addr{}, false
+	}
+	addr, err := netip.ParseAddr(strings.TrimSuffix(strings.TrimPrefix(s, "["), "]"))
+	if err != nil {
+		ap, err := netip.ParseAddrPort(s)
+		if err != nil {
+			return netip.Addr{}, false
+		}
+		addr = ap.Addr()
+	}
+	return addr.WithZone("").Unmap(), true
+}
+
+// NewSessionFromRequest 与 [Control.NewSessionContext] 相同，
+// 但是从请求获取context，客户端ip和user-agent。
+func (c *Control) NewSessionFromRequest(r *http.Request, UserName string) (Session, error) {
+	return c.NewSessionContext(r.Context(), c.ClientIP(r), r.UserAgent(), UserName)
+}
+
+// CheckFromRequest 与 [Control.CheckContext] 相同，
+// 但是从请求获取context，客户端ip和user-agent。
+func (c *Control) CheckFromRequest(r *http.Request, s *Session, ps ...PostInfo) (pass bool, err error) {
+	return c.CheckContext(r.Context(), c.ClientIP(r), r.UserAgent(), s, ps...)
+}
+
+// CheckLoginedFromRequest 与 [Control.CheckLoginedContext] 相同，
+// 但是从请求获取context，客户端ip，user-agent和cookie。
+// cookie分块保存时，会合并所有块。
+// 如果请求没有cookie，返回false和nil。
+func (c *Control) CheckLoginedFromRequest(r *http.Request, ps ...PostInfo) (bool, error, Session) {
+	cookie, err := c.readCookie(r)
+	if err != nil {
+		return false, nil, Session{}
+	}
+	return c.CheckLoginedContext(r.Context(), c.ClientIP(r), r.UserAgent(), cookie, ps...)
+}
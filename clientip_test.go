@@ -0,0 +1,46 @@
+package safesession
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	c := NewControl(nil, nil, 0, 0, nil, DB{})
+	if err := c.SetTrustedProxies("10.0.0.0/8", "::1", "fd00::/8"); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		remote  string
+		headers map[string]string
+		want    string
+	}{
+		{"direct", "1.2.3.4:5678", nil, "1.2.3.4"},
+		{"untrusted proxy", "1.2.3.4:5678", map[string]string{"X-Forwarded-For": "5.6.7.8"}, "1.2.3.4"},
+		{"x-forwarded-for", "10.0.0.1:80", map[string]string{"X-Forwarded-For": "9.9.9.9, 5.6.7.8, 10.0.0.2"}, "5.6.7.8"},
+		{"x-forwarded-for all trusted", "10.0.0.1:80", map[string]string{"X-Forwarded-For": "10.0.0.3, 10.0.0.2"}, "10.0.0.3"},
+		{"x-real-ip", "10.0.0.1:80", map[string]string{"X-Real-IP": "5.6.7.8"}, "5.6.7.8"},
+		{"forwarded", "[::1]:80", map[string]string{"Forwarded": `for=5.6.7.8;proto=https, For="[2001:db8::17]:4711"`}, "2001:db8::17"},
+		{"forwarded first", "10.0.0.1:80", map[string]string{"Forwarded": "for=5.6.7.8", "X-Forwarded-For": "1.1.1.1"}, "5.6.7.8"},
+		{"forwarded invalid", "10.0.0.1:80", map[string]string{"Forwarded": "for=unknown", "X-Forwarded-For": "1.1.1.1:443"}, "1.1.1.1"},
+		{"ipv4 mapped", "[::ffff:1.2.3.4]:80", nil, "1.2.3.4"},
+		{"ipv6 zone", "[fe80::1%eth0]:80", nil, "fe80::1"},
+		{"ipv6 normalize", "10.0.0.1:80", map[string]string{"X-Forwarded-For": "2001:DB8:0:0::1"}, "2001:db8::1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = tt.remote
+			for k, v := range tt.headers {
+				r.Header.Set(k, v)
+			}
+			if got := c.ClientIP(r); got != tt.want {
+				t.Fatalf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+	if err := c.SetTrustedProxies("not ip"); err == nil {
+		t.Fatal("should fail")
+	}
+}
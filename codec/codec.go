@@ -7,19 +7,40 @@ value1 value2 ......
 
 time.Time 编码为它的RFC3399Nano结果
 
-int64和float64编码为字符串表示
+整数，浮点数，复数和bool编码为字符串表示
 
-string直接写入
+string直接写入，不能包含byte(0)
+
+实现了 [encoding.TextMarshaler] 的字段编码为MarshalText的结果，
+否则实现了 [encoding.BinaryMarshaler] 的字段编码为MarshalBinary结果的base64编码
+
+指针先写入1或0表示是否为nil，不为nil时再写入指向的值
+
+切片和map先写入长度，为nil时写入空字符串，然后依次写入元素，
+map按编码后的键排序写入键和值
+
+数组依次写入元素
+
+结构体依次写入导出的字段，不写入带有 `codec:"-"` 标签的字段。
+不支持重命名字段，因为编码格式按字段顺序，不包含字段名，
+codec标签只有"-"有效。
 
 考虑到被编码值可能包含空格，所以编码后的分隔从空格改为byte(0)
+
+每种类型第一次编解码时生成编解码计划并缓存，之后直接使用计划，不再逐个查找字段的类型。
 */
 package codec
 
 import (
+	"bytes"
+	"encoding"
+	"encoding/base64"
 	"fmt"
 	"reflect"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -28,40 +49,19 @@ const sepb = byte(0)
 
 // Encode 将值编码为字符串。
 func Encode(v any) string {
+	return string(AppendEncode(nil, v))
+}
+
+// AppendEncode 将值编码后追加到dst，返回追加后的切片。
+// v是结构体指针，且dst容量足够时，不会分配内存，
+// 除非有字段是map，复数或实现了 [encoding.TextMarshaler] 等接口。
+func AppendEncode(dst []byte, v any) []byte {
 	r := reflect.ValueOf(v)
 	if r.Kind() == reflect.Ptr {
 		r = r.Elem()
 	}
-	var buf strings.Builder
-	encodeBuf(r, &buf)
-	return buf.String()
-}
-
-func encodeBuf(r reflect.Value, buf *strings.Builder) {
-	for i := 0; i < r.NumField(); i++ {
-		f := r.Field(i)
-		switch f.Kind() {
-		case reflect.String:
-			buf.WriteString(f.String())
-			buf.WriteString(sep)
-		case reflect.Struct:
-			if r.Type().Field(i).Type == timetime {
-				b := f.Interface().(time.Time).Format(time.RFC3339Nano)
-				buf.WriteString(b)
-				buf.WriteString(sep)
-			} else {
-				encodeBuf(f, buf)
-			}
-		case reflect.Float64:
-			fmt.Fprintf(buf, "%g", f.Float())
-			buf.WriteString(sep)
-		case reflect.Int64:
-			fmt.Fprintf(buf, "%d", f.Int())
-			buf.WriteString(sep)
-		default:
-			panic(fmt.Errorf("未知的类型 %s", f.Type()))
-		}
-	}
+	r = addressable(r)
+	return planFor(r.Type()).enc(dst, r)
 }
 
 // Decode 将字符串解码为指定类型的值。
@@ -73,53 +73,372 @@ func Decode[T any](v *T, code string) (ok bool) {
 		}
 	}()
 	r := reflect.ValueOf(v).Elem()
-	decodeStruct(r, code)
+	planFor(r.Type()).dec(r, code)
 	return true
 }
 
-func decodeStruct(r reflect.Value, code string) string {
-	for i := 0; i < r.NumField(); i++ {
-		f := r.Field(i)
-		code = decodeField(f, code)
+// plan 是一种类型的编解码计划。
+//
+// enc将r编码后追加到b，r是可寻址的。
+// dec从code解码一个值到r，返回剩下未解码的值，解码失败时panic。
+type plan struct {
+	enc func(b []byte, r reflect.Value) []byte
+	dec func(r reflect.Value, code string) string
+}
+
+// plans 缓存每种类型的编解码计划，键是 [reflect.Type] ，值是*plan。
+var plans sync.Map
+
+// planFor 返回类型t的编解码计划。
+func planFor(t reflect.Type) *plan {
+	if p, ok := plans.Load(t); ok {
+		return p.(*plan)
 	}
-	return code
+	p, _ := plans.LoadOrStore(t, newPlan(t))
+	return p.(*plan)
 }
 
-func decodeField(r reflect.Value, code string) string {
-	var v string
-	switch r.Kind() {
+// lazyPlan 返回一个函数，第一次调用时才获取类型t的编解码计划，
+// 用于指针，切片和map的元素，使递归类型不会无限递归地生成计划。
+func lazyPlan(t reflect.Type) func() *plan {
+	return sync.OnceValue(func() *plan { return planFor(t) })
+}
+
+// newPlan 生成类型t的编解码计划。
+func newPlan(t reflect.Type) *plan {
+	if t == timetime {
+		return &plan{
+			enc: func(b []byte, r reflect.Value) []byte {
+				b = r.Addr().Interface().(*time.Time).AppendFormat(b, time.RFC3339Nano)
+				return append(b, sepb)
+			},
+			dec: func(r reflect.Value, code string) string {
+				code, v := getValue(code)
+				t, err := time.Parse(time.RFC3339Nano, v)
+				if err != nil {
+					panic(err)
+				}
+				*r.Addr().Interface().(*time.Time) = t
+				return code
+			},
+		}
+	}
+	return withMarshaler(t, kindPlan(t))
+}
+
+// kindPlan 按类型的种类生成类型t的编解码计划。
+func kindPlan(t reflect.Type) *plan {
+	switch t.Kind() {
 	case reflect.String:
-		code, v = getValue(code)
-		r.SetString(v)
+		return &plan{
+			enc: func(b []byte, r reflect.Value) []byte {
+				return write(b, r.String())
+			},
+			dec: func(r reflect.Value, code string) string {
+				code, v := getValue(code)
+				r.SetString(v)
+				return code
+			},
+		}
+	case reflect.Bool:
+		return &plan{
+			enc: func(b []byte, r reflect.Value) []byte {
+				return append(strconv.AppendBool(b, r.Bool()), sepb)
+			},
+			dec: func(r reflect.Value, code string) string {
+				code, v := getValue(code)
+				b, err := strconv.ParseBool(v)
+				if err != nil {
+					panic(err)
+				}
+				r.SetBool(b)
+				return code
+			},
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		bits := t.Bits()
+		return &plan{
+			enc: func(b []byte, r reflect.Value) []byte {
+				return append(strconv.AppendInt(b, r.Int(), 10), sepb)
+			},
+			dec: func(r reflect.Value, code string) string {
+				code, v := getValue(code)
+				vi, err := strconv.ParseInt(v, 10, bits)
+				if err != nil {
+					panic(err)
+				}
+				r.SetInt(vi)
+				return code
+			},
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		bits := t.Bits()
+		return &plan{
+			enc: func(b []byte, r reflect.Value) []byte {
+				return append(strconv.AppendUint(b, r.Uint(), 10), sepb)
+			},
+			dec: func(r reflect.Value, code string) string {
+				code, v := getValue(code)
+				vu, err := strconv.ParseUint(v, 10, bits)
+				if err != nil {
+					panic(err)
+				}
+				r.SetUint(vu)
+				return code
+			},
+		}
+	case reflect.Float32, reflect.Float64:
+		bits := t.Bits()
+		return &plan{
+			enc: func(b []byte, r reflect.Value) []byte {
+				return append(strconv.AppendFloat(b, r.Float(), 'g', -1, bits), sepb)
+			},
+			dec: func(r reflect.Value, code string) string {
+				code, v := getValue(code)
+				f, err := strconv.ParseFloat(v, bits)
+				if err != nil {
+					panic(err)
+				}
+				r.SetFloat(f)
+				return code
+			},
+		}
+	case reflect.Complex64, reflect.Complex128:
+		bits := t.Bits()
+		return &plan{
+			enc: func(b []byte, r reflect.Value) []byte {
+				return write(b, strconv.FormatComplex(r.Complex(), 'g', -1, bits))
+			},
+			dec: func(r reflect.Value, code string) string {
+				code, v := getValue(code)
+				c, err := strconv.ParseComplex(v, bits)
+				if err != nil {
+					panic(err)
+				}
+				r.SetComplex(c)
+				return code
+			},
+		}
 	case reflect.Struct:
-		if r.Type() == timetime {
-			code, v = getValue(code)
-			t, err := time.Parse(time.RFC3339Nano, v)
+		return structPlan(t)
+	case reflect.Pointer:
+		elem := lazyPlan(t.Elem())
+		return &plan{
+			enc: func(b []byte, r reflect.Value) []byte {
+				if r.IsNil() {
+					return write(b, "0")
+				}
+				return elem().enc(write(b, "1"), r.Elem())
+			},
+			dec: func(r reflect.Value, code string) string {
+				code, v := getValue(code)
+				switch v {
+				case "0":
+					r.SetZero()
+				case "1":
+					p := reflect.New(t.Elem())
+					code = elem().dec(p.Elem(), code)
+					r.Set(p)
+				default:
+					panic(fmt.Errorf("无效的指针标记 %q", v))
+				}
+				return code
+			},
+		}
+	case reflect.Array:
+		elem := lazyPlan(t.Elem())
+		return &plan{
+			enc: func(b []byte, r reflect.Value) []byte {
+				for i := 0; i < r.Len(); i++ {
+					b = elem().enc(b, r.Index(i))
+				}
+				return b
+			},
+			dec: func(r reflect.Value, code string) string {
+				for i := 0; i < r.Len(); i++ {
+					code = elem().dec(r.Index(i), code)
+				}
+				return code
+			},
+		}
+	case reflect.Slice:
+		elem := lazyPlan(t.Elem())
+		return &plan{
+			enc: func(b []byte, r reflect.Value) []byte {
+				if r.IsNil() {
+					return write(b, "")
+				}
+				b = append(strconv.AppendInt(b, int64(r.Len()), 10), sepb)
+				for i := 0; i < r.Len(); i++ {
+					b = elem().enc(b, r.Index(i))
+				}
+				return b
+			},
+			dec: func(r reflect.Value, code string) string {
+				code, n := getLen(code, r)
+				if n < 0 {
+					return code
+				}
+				s := reflect.MakeSlice(t, n, n)
+				for i := 0; i < n; i++ {
+					code = elem().dec(s.Index(i), code)
+				}
+				r.Set(s)
+				return code
+			},
+		}
+	case reflect.Map:
+		key, elem := lazyPlan(t.Key()), lazyPlan(t.Elem())
+		return &plan{
+			enc: func(b []byte, r reflect.Value) []byte {
+				if r.IsNil() {
+					return write(b, "")
+				}
+				b = append(strconv.AppendInt(b, int64(r.Len()), 10), sepb)
+				// 按编码后的键排序，使编码结果是确定的。
+				type kv struct{ k, v []byte }
+				kvs := make([]kv, 0, r.Len())
+				k := reflect.New(t.Key()).Elem()
+				v := reflect.New(t.Elem()).Elem()
+				iter := r.MapRange()
+				for iter.Next() {
+					k.SetIterKey(iter)
+					v.SetIterValue(iter)
+					kvs = append(kvs, kv{key().enc(nil, k), elem().enc(nil, v)})
+				}
+				slices.SortFunc(kvs, func(a, b kv) int { return bytes.Compare(a.k, b.k) })
+				for _, v := range kvs {
+					b = append(append(b, v.k...), v.v...)
+				}
+				return b
+			},
+			dec: func(r reflect.Value, code string) string {
+				code, n := getLen(code, r)
+				if n < 0 {
+					return code
+				}
+				m := reflect.MakeMapWithSize(t, n)
+				for i := 0; i < n; i++ {
+					k := reflect.New(t.Key()).Elem()
+					code = key().dec(k, code)
+					e := reflect.New(t.Elem()).Elem()
+					code = elem().dec(e, code)
+					m.SetMapIndex(k, e)
+				}
+				r.Set(m)
+				return code
+			},
+		}
+	}
+	err := fmt.Errorf("未知的类型 %s", t)
+	return &plan{
+		enc: func(b []byte, r reflect.Value) []byte { panic(err) },
+		dec: func(r reflect.Value, code string) string { panic(err) },
+	}
+}
+
+// structPlan 生成结构体类型t的编解码计划。
+func structPlan(t reflect.Type) *plan {
+	type field struct {
+		index int
+		p     *plan
+	}
+	var fields []field
+	for i := 0; i < t.NumField(); i++ {
+		if skip(t.Field(i)) {
+			continue
+		}
+		fields = append(fields, field{i, planFor(t.Field(i).Type)})
+	}
+	return &plan{
+		enc: func(b []byte, r reflect.Value) []byte {
+			for _, f := range fields {
+				b = f.p.enc(b, r.Field(f.index))
+			}
+			return b
+		},
+		dec: func(r reflect.Value, code string) string {
+			for _, f := range fields {
+				code = f.p.dec(r.Field(f.index), code)
+			}
+			return code
+		},
+	}
+}
+
+// withMarshaler 如果类型t实现了 [encoding.TextMarshaler] 或 [encoding.BinaryMarshaler] ，
+// 使用它们代替p编码，解码同理。
+// 指针不检查，而是检查指向的值，以便编码nil。
+func withMarshaler(t reflect.Type, p *plan) *plan {
+	if t.Kind() == reflect.Pointer {
+		return p
+	}
+	pt := reflect.PointerTo(t)
+	switch {
+	case pt.Implements(textMarshaler):
+		p.enc = func(b []byte, r reflect.Value) []byte {
+			v, err := r.Addr().Interface().(encoding.TextMarshaler).MarshalText()
+			if err != nil {
+				panic(err)
+			}
+			return append(append(b, v...), sepb)
+		}
+	case pt.Implements(binaryMarshaler):
+		p.enc = func(b []byte, r reflect.Value) []byte {
+			v, err := r.Addr().Interface().(encoding.BinaryMarshaler).MarshalBinary()
 			if err != nil {
 				panic(err)
 			}
-			r.Set(reflect.ValueOf(t))
+			return append(base64.StdEncoding.AppendEncode(b, v), sepb)
+		}
+	}
+	switch {
+	case pt.Implements(textUnmarshaler):
+		p.dec = func(r reflect.Value, code string) string {
+			code, v := getValue(code)
+			if err := r.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(v)); err != nil {
+				panic(err)
+			}
 			return code
 		}
-		code = decodeStruct(r, code)
-	case reflect.Float64:
-		code, v = getValue(code)
-		f, err := strconv.ParseFloat(v, 64)
-		if err != nil {
-			panic(err)
-		}
-		r.SetFloat(f)
-	case reflect.Int64:
-		code, v = getValue(code)
-		vi, err := strconv.ParseInt(v, 10, 64)
-		if err != nil {
-			panic(err)
-		}
-		r.SetInt(vi)
-	default:
-		panic(fmt.Errorf("未知的类型 %s", r.Type()))
-	}
-	return code
+	case pt.Implements(binaryUnmarshaler):
+		p.dec = func(r reflect.Value, code string) string {
+			code, v := getValue(code)
+			b, err := base64.StdEncoding.DecodeString(v)
+			if err != nil {
+				panic(err)
+			}
+			if err := r.Addr().Interface().(encoding.BinaryUnmarshaler).UnmarshalBinary(b); err != nil {
+				panic(err)
+			}
+			return code
+		}
+	}
+	return p
+}
+
+// write 将v和分隔符追加到b。
+func write(b []byte, v string) []byte {
+	return append(append(b, v...), sepb)
+}
+
+// getLen 获取切片或map的长度，并返回剩下未解码的值。
+// 如果是nil，将r设为nil，返回-1。
+func getLen(code string, r reflect.Value) (string, int) {
+	code, v := getValue(code)
+	if v == "" {
+		r.SetZero()
+		return code, -1
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		panic(err)
+	}
+	// 每个元素至少占用一个分隔符，防止恶意的长度导致分配过多内存。
+	if n < 0 || n > strings.Count(code, sep) {
+		panic(fmt.Errorf("无效的长度 %d", n))
+	}
+	return code, n
 }
 
 // getValue 获取一个值，并返回剩下未解码的值。
@@ -136,4 +455,32 @@ func getValue(code string) (string, string) {
 	return code, v
 }
 
+// skip 报告是否跳过结构体字段。
+// 未导出的字段和带有 `codec:"-"` 标签的字段被跳过。
+func skip(f reflect.StructField) bool {
+	if !f.IsExported() {
+		return true
+	}
+	name, _, _ := strings.Cut(f.Tag.Get("codec"), ",")
+	return name == "-"
+}
+
+// addressable 返回r的可寻址的副本，
+// 使指针接收者实现的 [encoding.TextMarshaler] 等也能被使用。
+func addressable(r reflect.Value) reflect.Value {
+	if r.CanAddr() {
+		return r
+	}
+	p := reflect.New(r.Type()).Elem()
+	p.Set(r)
+	return p
+}
+
 var timetime = reflect.TypeOf(time.Time{})
+
+var (
+	textMarshaler     = reflect.TypeFor[encoding.TextMarshaler]()
+	binaryMarshaler   = reflect.TypeFor[encoding.BinaryMarshaler]()
+	textUnmarshaler   = reflect.TypeFor[encoding.TextUnmarshaler]()
+	binaryUnmarshaler = reflect.TypeFor[encoding.BinaryUnmarshaler]()
+)
@@ -1,6 +1,8 @@
 package codec_test
 
 import (
+	"bytes"
+	"net/netip"
 	"net/url"
 	"reflect"
 	"strings"
@@ -96,6 +98,9 @@ func FuzzCodec(f *testing.F) {
 func checkStruct(v reflect.Value) {
 	for i := 0; i < v.NumField(); i++ {
 		f := v.Field(i)
+		if !f.CanSet() {
+			continue
+		}
 		if f.Kind() == reflect.String {
 			s := f.String()
 			s = strings.ReplaceAll(s, "\x00", "")
@@ -106,3 +111,110 @@ func checkStruct(v reflect.Value) {
 		}
 	}
 }
+
+type kinds struct {
+	MFA     bool
+	Age     int
+	Level   uint8
+	Score   float32
+	C       complex128
+	Roles   []string
+	Claims  map[string]string
+	Counts  map[int][]int64
+	Parent  *kinds
+	Addr    netip.Addr
+	Bin     binMarshaler
+	Array   [2]int16
+	Secret  string `codec:"-"`
+	Note    string
+	private string
+}
+
+// binMarshaler 只实现了 [encoding.BinaryMarshaler] ，编码结果可能包含byte(0)。
+type binMarshaler struct {
+	B []byte
+}
+
+func (b *binMarshaler) MarshalBinary() ([]byte, error) {
+	return b.B, nil
+}
+
+func (b *binMarshaler) UnmarshalBinary(data []byte) error {
+	b.B = nil
+	if len(data) != 0 {
+		b.B = bytes.Clone(data)
+	}
+	return nil
+}
+
+func TestKinds(t *testing.T) {
+	v := kinds{
+		MFA:     true,
+		Age:     -3,
+		Level:   255,
+		Score:   1.5,
+		C:       complex(1, -2),
+		Roles:   []string{"admin", "", "a b"},
+		Claims:  map[string]string{"b": "2", "a": "1"},
+		Counts:  map[int][]int64{1: nil, 2: {3, 4}},
+		Parent:  &kinds{Roles: []string{}, Addr: netip.MustParseAddr("::1")},
+		Addr:    netip.MustParseAddr("192.168.0.1"),
+		Bin:     binMarshaler{B: []byte{0, 1, 0}},
+		Array:   [2]int16{-1, 1},
+		Secret:  "secret",
+		Note:    "note",
+		private: "private",
+	}
+	c := Encode(v)
+	if c != Encode(&v) {
+		t.Fatal("map order should be deterministic")
+	}
+	var got kinds
+	if !Decode(&got, c) {
+		t.Fatalf("decode failed: %q", c)
+	}
+	want := v
+	want.Secret, want.private = "", ""
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("%+v != %+v", got, want)
+	}
+
+	for _, code := range []string{"", "true\x00x\x00", strings.Replace(c, "\x003\x00admin", "\x009999\x00admin", 1)} {
+		if Decode(new(kinds), code) {
+			t.Fatalf("%q should fail", code)
+		}
+	}
+}
+
+func FuzzKinds(f *testing.F) {
+	f.Add(true, -3, uint8(1), float32(1.5), "admin", "k", "v", int64(4), true, []byte{0, 1}, int16(7))
+	f.Fuzz(func(t *testing.T, MFA bool, Age int, Level uint8, Score float32, Role, K, V string, Count int64, HasParent bool, Bin []byte, A int16) {
+		if strings.Contains(Role+K+V, "\x00") || Score != Score {
+			t.Skip()
+		}
+		v := kinds{
+			MFA:    MFA,
+			Age:    Age,
+			Level:  Level,
+			Score:  Score,
+			Roles:  []string{Role},
+			Claims: map[string]string{K: V},
+			Counts: map[int][]int64{Age: {Count}},
+			Bin:    binMarshaler{B: Bin},
+			Array:  [2]int16{A, -A},
+		}
+		if HasParent {
+			v.Parent = &kinds{Note: Role}
+		}
+		var got kinds
+		if !Decode(&got, Encode(v)) {
+			t.Fatalf("decode failed: %+v", v)
+		}
+		if len(v.Bin.B) == 0 {
+			v.Bin.B = nil
+		}
+		if !reflect.DeepEqual(got, v) {
+			t.Fatalf("%+v != %+v", got, v)
+		}
+	})
+}
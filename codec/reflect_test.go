@@ -0,0 +1,436 @@
+package codec
+
+import (
+	"encoding"
+	"encoding/base64"
+	"fmt"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 下面是不使用缓存的编解码计划的实现，用于基准测试对比。
+
+func reflectEncode(v any) string {
+	r := reflect.ValueOf(v)
+	if r.Kind() == reflect.Ptr {
+		r = r.Elem()
+	}
+	var buf strings.Builder
+	reflectEncodeBuf(reflectAddressable(r), &buf)
+	return buf.String()
+}
+
+func reflectEncodeBuf(r reflect.Value, buf *strings.Builder) {
+	t := r.Type()
+	for i := 0; i < r.NumField(); i++ {
+		if skip(t.Field(i)) {
+			continue
+		}
+		reflectEncodeField(r.Field(i), buf)
+	}
+}
+
+func reflectEncodeField(f reflect.Value, buf *strings.Builder) {
+	if f.Type() == timetime {
+		reflectWrite(buf, f.Interface().(time.Time).Format(time.RFC3339Nano))
+		return
+	}
+	if m, ok := reflectMarshaler(f); ok {
+		switch m := m.(type) {
+		case encoding.TextMarshaler:
+			b, err := m.MarshalText()
+			if err != nil {
+				panic(err)
+			}
+			reflectWrite(buf, string(b))
+		case encoding.BinaryMarshaler:
+			b, err := m.MarshalBinary()
+			if err != nil {
+				panic(err)
+			}
+			reflectWrite(buf, base64.StdEncoding.EncodeToString(b))
+		}
+		return
+	}
+	switch f.Kind() {
+	case reflect.String:
+		reflectWrite(buf, f.String())
+	case reflect.Bool:
+		reflectWrite(buf, strconv.FormatBool(f.Bool()))
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		reflectWrite(buf, strconv.FormatInt(f.Int(), 10))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		reflectWrite(buf, strconv.FormatUint(f.Uint(), 10))
+	case reflect.Float32, reflect.Float64:
+		reflectWrite(buf, strconv.FormatFloat(f.Float(), 'g', -1, f.Type().Bits()))
+	case reflect.Complex64, reflect.Complex128:
+		reflectWrite(buf, strconv.FormatComplex(f.Complex(), 'g', -1, f.Type().Bits()))
+	case reflect.Struct:
+		reflectEncodeBuf(f, buf)
+	case reflect.Pointer:
+		if f.IsNil() {
+			reflectWrite(buf, "0")
+			return
+		}
+		reflectWrite(buf, "1")
+		reflectEncodeField(f.Elem(), buf)
+	case reflect.Array:
+		for i := 0; i < f.Len(); i++ {
+			reflectEncodeField(f.Index(i), buf)
+		}
+	case reflect.Slice:
+		if f.IsNil() {
+			reflectWrite(buf, "")
+			return
+		}
+		reflectWrite(buf, strconv.Itoa(f.Len()))
+		for i := 0; i < f.Len(); i++ {
+			reflectEncodeField(f.Index(i), buf)
+		}
+	case reflect.Map:
+		if f.IsNil() {
+			reflectWrite(buf, "")
+			return
+		}
+		reflectWrite(buf, strconv.Itoa(f.Len()))
+		// 按编码后的键排序，使编码结果是确定的。
+		type kv struct{ k, v string }
+		kvs := make([]kv, 0, f.Len())
+		iter := f.MapRange()
+		for iter.Next() {
+			var k, v strings.Builder
+			reflectEncodeField(reflectAddressable(iter.Key()), &k)
+			reflectEncodeField(reflectAddressable(iter.Value()), &v)
+			kvs = append(kvs, kv{k.String(), v.String()})
+		}
+		slices.SortFunc(kvs, func(a, b kv) int { return strings.Compare(a.k, b.k) })
+		for _, v := range kvs {
+			buf.WriteString(v.k)
+			buf.WriteString(v.v)
+		}
+	default:
+		panic(fmt.Errorf("未知的类型 %s", f.Type()))
+	}
+}
+
+func reflectWrite(buf *strings.Builder, v string) {
+	buf.WriteString(v)
+	buf.WriteString(sep)
+}
+
+// Decode 将字符串解码为指定类型的值。
+// 如果解码失败，返回false。
+func reflectDecode[T any](v *T, code string) (ok bool) {
+	defer func() {
+		if err := recover(); err != nil {
+			ok = false
+		}
+	}()
+	r := reflect.ValueOf(v).Elem()
+	reflectDecodeStruct(r, code)
+	return true
+}
+
+func reflectDecodeStruct(r reflect.Value, code string) string {
+	t := r.Type()
+	for i := 0; i < r.NumField(); i++ {
+		if skip(t.Field(i)) {
+			continue
+		}
+		f := r.Field(i)
+		code = reflectDecodeField(f, code)
+	}
+	return code
+}
+
+func reflectDecodeField(r reflect.Value, code string) string {
+	var v string
+	if r.Type() == timetime {
+		code, v = getValue(code)
+		t, err := time.Parse(time.RFC3339Nano, v)
+		if err != nil {
+			panic(err)
+		}
+		r.Set(reflect.ValueOf(t))
+		return code
+	}
+	if m, ok := reflectUnmarshaler(r); ok {
+		code, v = getValue(code)
+		switch m := m.(type) {
+		case encoding.TextUnmarshaler:
+			if err := m.UnmarshalText([]byte(v)); err != nil {
+				panic(err)
+			}
+		case encoding.BinaryUnmarshaler:
+			b, err := base64.StdEncoding.DecodeString(v)
+			if err != nil {
+				panic(err)
+			}
+			if err := m.UnmarshalBinary(b); err != nil {
+				panic(err)
+			}
+		}
+		return code
+	}
+	switch r.Kind() {
+	case reflect.String:
+		code, v = getValue(code)
+		r.SetString(v)
+	case reflect.Bool:
+		code, v = getValue(code)
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			panic(err)
+		}
+		r.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		code, v = getValue(code)
+		vi, err := strconv.ParseInt(v, 10, r.Type().Bits())
+		if err != nil {
+			panic(err)
+		}
+		r.SetInt(vi)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		code, v = getValue(code)
+		vu, err := strconv.ParseUint(v, 10, r.Type().Bits())
+		if err != nil {
+			panic(err)
+		}
+		r.SetUint(vu)
+	case reflect.Float32, reflect.Float64:
+		code, v = getValue(code)
+		f, err := strconv.ParseFloat(v, r.Type().Bits())
+		if err != nil {
+			panic(err)
+		}
+		r.SetFloat(f)
+	case reflect.Complex64, reflect.Complex128:
+		code, v = getValue(code)
+		c, err := strconv.ParseComplex(v, r.Type().Bits())
+		if err != nil {
+			panic(err)
+		}
+		r.SetComplex(c)
+	case reflect.Struct:
+		code = reflectDecodeStruct(r, code)
+	case reflect.Pointer:
+		code, v = getValue(code)
+		switch v {
+		case "0":
+			r.SetZero()
+		case "1":
+			p := reflect.New(r.Type().Elem())
+			code = reflectDecodeField(p.Elem(), code)
+			r.Set(p)
+		default:
+			panic(fmt.Errorf("无效的指针标记 %q", v))
+		}
+	case reflect.Array:
+		for i := 0; i < r.Len(); i++ {
+			code = reflectDecodeField(r.Index(i), code)
+		}
+	case reflect.Slice:
+		var n int
+		code, n = reflectGetLen(code, r)
+		if n < 0 {
+			return code
+		}
+		s := reflect.MakeSlice(r.Type(), n, n)
+		for i := 0; i < n; i++ {
+			code = reflectDecodeField(s.Index(i), code)
+		}
+		r.Set(s)
+	case reflect.Map:
+		var n int
+		code, n = reflectGetLen(code, r)
+		if n < 0 {
+			return code
+		}
+		m := reflect.MakeMapWithSize(r.Type(), n)
+		t := r.Type()
+		for i := 0; i < n; i++ {
+			k := reflect.New(t.Key()).Elem()
+			code = reflectDecodeField(k, code)
+			e := reflect.New(t.Elem()).Elem()
+			code = reflectDecodeField(e, code)
+			m.SetMapIndex(k, e)
+		}
+		r.Set(m)
+	default:
+		panic(fmt.Errorf("未知的类型 %s", r.Type()))
+	}
+	return code
+}
+
+// getLen 获取切片或map的长度，并返回剩下未解码的值。
+// 如果是nil，将r设为nil，返回-1。
+func reflectGetLen(code string, r reflect.Value) (string, int) {
+	code, v := getValue(code)
+	if v == "" {
+		r.SetZero()
+		return code, -1
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		panic(err)
+	}
+	// 每个元素至少占用一个分隔符，防止恶意的长度导致分配过多内存。
+	if n < 0 || n > strings.Count(code, sep) {
+		panic(fmt.Errorf("无效的长度 %d", n))
+	}
+	return code, n
+}
+
+// marshaler 返回r实现的 [encoding.TextMarshaler] 或 [encoding.BinaryMarshaler] 。
+// 指针不检查，而是检查指向的值，以便编码nil。
+func reflectMarshaler(r reflect.Value) (any, bool) {
+	if r.Kind() == reflect.Pointer || !r.CanAddr() {
+		return nil, false
+	}
+	switch m := r.Addr().Interface().(type) {
+	case encoding.TextMarshaler:
+		return m, true
+	case encoding.BinaryMarshaler:
+		return m, true
+	}
+	return nil, false
+}
+
+// unmarshaler 返回r实现的 [encoding.TextUnmarshaler] 或 [encoding.BinaryUnmarshaler] 。
+// 指针不检查，与 [marshaler] 一致。
+func reflectUnmarshaler(r reflect.Value) (any, bool) {
+	if r.Kind() == reflect.Pointer || !r.CanAddr() {
+		return nil, false
+	}
+	switch m := r.Addr().Interface().(type) {
+	case encoding.TextUnmarshaler:
+		return m, true
+	case encoding.BinaryUnmarshaler:
+		return m, true
+	}
+	return nil, false
+}
+
+// addressable 返回r的可寻址的副本，
+// 使指针接收者实现的 [encoding.TextMarshaler] 等也能被使用。
+func reflectAddressable(r reflect.Value) reflect.Value {
+	if r.CanAddr() {
+		return r
+	}
+	p := reflect.New(r.Type()).Elem()
+	p.Set(r)
+	return p
+}
+
+type benchSession struct {
+	ID         string
+	CreateTime time.Time
+	Ip         struct {
+		Country, Region, City string
+		ISP                   string
+		Longitude, Latitude   float64
+		AS                    int64
+	}
+	Gps struct {
+		Longitude, Latitude float64
+	}
+	CSRF_TOKEN    string
+	Os, OsVersion string
+	Name          string
+	Device        string
+	Broswer       string
+	Screen        struct {
+		Width, Height int64
+	}
+	PNum int64
+}
+
+func newBenchSession() *benchSession {
+	s := &benchSession{
+		ID:         "mNq0Xhb2Y0mV8FvJQ3pZq8h1pZ1r4m0Q",
+		CreateTime: time.Date(2026, 1, 2, 3, 4, 5, 6, time.UTC),
+		Os:         "Windows",
+		OsVersion:  "10.0",
+		Name:       "user",
+		Broswer:    "Edge",
+		PNum:       8,
+	}
+	s.Ip.Country, s.Ip.Region, s.Ip.ISP, s.Ip.AS = "CN", "Shanghai", "China Unicom", 4837
+	s.Ip.Longitude, s.Ip.Latitude = 121.47, 31.23
+	s.Gps.Longitude, s.Gps.Latitude = 121.5, 31.2
+	s.Screen.Width, s.Screen.Height = 1920, 1080
+	return s
+}
+
+// tree 是递归类型。
+type tree struct {
+	Name     string
+	Children []tree
+	Next     *tree
+	Tags     map[string]*tree
+}
+
+func TestPlanSameAsReflect(t *testing.T) {
+	tr := &tree{Name: "root", Children: []tree{{Name: "a"}, {Name: "b", Next: &tree{Name: "c"}}}, Tags: map[string]*tree{"x": {Name: "x"}, "y": nil}}
+	for _, v := range []any{newBenchSession(), tr} {
+		got, want := Encode(v), reflectEncode(v)
+		if got != want {
+			t.Fatalf("%q != %q", got, want)
+		}
+	}
+	var got tree
+	if !Decode(&got, Encode(tr)) || !reflect.DeepEqual(&got, tr) {
+		t.Fatalf("%+v != %+v", got, tr)
+	}
+	b := AppendEncode([]byte("prefix"), tr)
+	if string(b) != "prefix"+Encode(tr) {
+		t.Fatalf("%q", b)
+	}
+	if n := testing.AllocsPerRun(100, func() { b = AppendEncode(b[:0], newBenchSession()) }); n > 1 {
+		t.Fatalf("AppendEncode allocs %v", n)
+	}
+}
+
+func BenchmarkEncode(b *testing.B) {
+	s := newBenchSession()
+	b.Run("plan", func(b *testing.B) {
+		b.ReportAllocs()
+		for b.Loop() {
+			Encode(s)
+		}
+	})
+	b.Run("append", func(b *testing.B) {
+		b.ReportAllocs()
+		buf := make([]byte, 0, 512)
+		for b.Loop() {
+			buf = AppendEncode(buf[:0], s)
+		}
+	})
+	b.Run("reflect", func(b *testing.B) {
+		b.ReportAllocs()
+		for b.Loop() {
+			reflectEncode(s)
+		}
+	})
+}
+
+func BenchmarkDecode(b *testing.B) {
+	code := Encode(newBenchSession())
+	var s benchSession
+	b.Run("plan", func(b *testing.B) {
+		b.ReportAllocs()
+		for b.Loop() {
+			Decode(&s, code)
+		}
+	})
+	b.Run("reflect", func(b *testing.B) {
+		b.ReportAllocs()
+		for b.Loop() {
+			reflectDecode(&s, code)
+		}
+	})
+}
@@ -0,0 +1,74 @@
+package safesession
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+var ErrCSRF = errors.New("CSRF验证失败，请刷新页面后重试")
+
+const (
+	// CSRFHeader 是 [Control.VerifyCSRF] 读取CSRF token的请求标头。
+	CSRFHeader = "X-CSRF-Token"
+	// CSRFField 是 [Control.VerifyCSRF] 读取CSRF token的表单字段，
+	// 请求标头中没有CSRF token时使用。
+	CSRFField = "csrf_token"
+)
+
+// IssueCSRF 随机生成一个CSRF token，保存到 [Session.CSRF_TOKEN] 并重新响应cookie，
+// 返回的token应该放在表单的隐藏字段或请求标头中。
+// 之前签发的token会失效。
+// 如果 [Control.SetSession] 失败，返回空字符串。
+// 只要每次调用的w不同，从多个goroutine调用是安全的。
+func (c *Control) IssueCSRF(s *Session, w http.ResponseWriter) string {
+	return c.IssueCSRFFor(s, w, "", "")
+}
+
+// IssueCSRFFor 与 [Control.IssueCSRF] 相同，
+// 但是token只能用于请求方法为method，路径为path的请求。
+// method或path为空字符串时，不限制请求方法或路径。
+func (c *Control) IssueCSRFFor(s *Session, w http.ResponseWriter, method, path string) string {
+	var b [32]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(err)
+	}
+	token := base64.RawURLEncoding.EncodeToString(b[:])
+	// token不包含空格，所以可以使用空格分隔。
+	s.CSRF_TOKEN = token + " " + method + " " + path
+	if err := c.SetSession(s, w); err != nil {
+		return ""
+	}
+	return token
+}
+
+// VerifyCSRF 验证请求中的CSRF token是否是 [Control.IssueCSRF] 签发的。
+//
+// 依次从 [CSRFHeader] 请求标头和 [CSRFField] 表单字段读取token，使用恒定时间比较。
+// 未通过验证时返回 [ErrCSRF] 。
+// 通过验证后token会失效，以便每次敏感操作使用不同的token，
+// 调用者应该调用 [Control.SetSession] 或 [Control.IssueCSRF] 重新响应cookie。
+// 从多个goroutine调用是安全的。
+func (c *Control) VerifyCSRF(r *http.Request, s *Session) error {
+	// 兼容调用者自行设置的不带请求方法和路径的CSRF_TOKEN。
+	want, bind, _ := strings.Cut(s.CSRF_TOKEN, " ")
+	if want == "" {
+		return ErrCSRF
+	}
+	got := r.Header.Get(CSRFHeader)
+	if got == "" {
+		got = r.PostFormValue(CSRFField)
+	}
+	if subtle.ConstantTimeCompare([]byte(got), []byte(want)) != 1 {
+		return ErrCSRF
+	}
+	method, path, _ := strings.Cut(bind, " ")
+	if (method != "" && method != r.Method) || (path != "" && path != r.URL.Path) {
+		return ErrCSRF
+	}
+	s.CSRF_TOKEN = ""
+	return nil
+}
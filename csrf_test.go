@@ -0,0 +1,51 @@
+package safesession
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestCSRF(t *testing.T) {
+	s := c.NewSession("192.168.0.3", user_agent, "ok")
+	r := httptest.NewRequest("POST", "/transfer", nil)
+	if err := c.VerifyCSRF(r, &s); err != ErrCSRF {
+		t.Fatal("no token issued, should fail")
+	}
+
+	w := httptest.NewRecorder()
+	token := c.IssueCSRF(&s, w)
+	if token == "" || len(w.Result().Cookies()) != 1 {
+		t.Fatal("should issue token and re-issue cookie")
+	}
+	ok, err, se := c.CheckLogined("192.168.0.3", user_agent, w.Result().Cookies()[0])
+	if !ok || err != nil {
+		t.Fatal(ok, err)
+	}
+	r = httptest.NewRequest("POST", "/transfer", nil)
+	r.Header.Set(CSRFHeader, token[1:])
+	if err := c.VerifyCSRF(r, &se); err != ErrCSRF {
+		t.Fatal("wrong token should fail")
+	}
+	r.Header.Set(CSRFHeader, token)
+	if err := c.VerifyCSRF(r, &se); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.VerifyCSRF(r, &se); err != ErrCSRF {
+		t.Fatal("token should be single-use")
+	}
+
+	token = c.IssueCSRFFor(&s, httptest.NewRecorder(), "POST", "/transfer")
+	form := url.Values{CSRFField: {token}}.Encode()
+	r = httptest.NewRequest("POST", "/other", strings.NewReader(form))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if err := c.VerifyCSRF(r, &s); err != ErrCSRF {
+		t.Fatal("wrong path should fail")
+	}
+	r = httptest.NewRequest("POST", "/transfer", strings.NewReader(form))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if err := c.VerifyCSRF(r, &s); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,144 @@
+package safesession
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"time"
+)
+
+var ErrNotSupported = errors.New("登录会话存储不支持此操作")
+var ErrSessionNotFound = errors.New("登录会话不存在或不属于该用户")
+
+// DeviceSummary 是登录会话的设备摘要，用于显示用户已登录的设备。
+// 不包含浏览器指纹，CSRF_TOKEN等不应该在服务器保存的信息。
+type DeviceSummary struct {
+	// ID 是登录会话的ID。
+	ID string
+	// UserName 是用来登录的用户的唯一身份表示。
+	UserName string
+	// CreateTime 是最近一次登录的时间。
+	CreateTime time.Time
+	// 下列字段是登录时的客户端设备信息。
+	Os, OsVersion string
+	Browser       string
+	Screen        Screen
+	// 下列字段是登录时的ip信息。
+	Country, Region, City string
+	ISP                   string
+}
+
+// DeviceStore 是可以保存设备摘要的 [Store] ，
+// 用于 [Control.ListSessions] ， [Control.Revoke] 和 [Control.RevokeAllExcept] 。
+// Delete应该同时删除设备摘要。
+type DeviceStore interface {
+	Store
+	// SaveDevice 保存登录会话的设备摘要。
+	SaveDevice(ctx context.Context, d DeviceSummary) error
+	// Devices 返回用户所有未过期的登录会话的设备摘要，
+	// CreateTime是数据库中的最近一次登录时间。
+	Devices(ctx context.Context, UserName string) ([]DeviceSummary, error)
+}
+
+// summary 返回s的设备摘要。
+func (s *Session) summary() DeviceSummary {
+	return DeviceSummary{
+		ID:         s.ID,
+		UserName:   s.Name,
+		CreateTime: s.CreateTime,
+		Os:         s.Os,
+		OsVersion:  s.OsVersion,
+		Browser:    s.Broswer,
+		Screen:     s.Screen,
+		Country:    s.Ip.Country,
+		Region:     s.Ip.Region,
+		City:       s.Ip.City,
+		ISP:        s.Ip.ISP,
+	}
+}
+
+// SaveDevice 如果数据库是 [DeviceStore] ，保存s的设备摘要，否则什么也不做。
+// [Control.NewSessionContext] 和 [Control.CompleteStepUp] 会自动调用，
+// 调用 [Session.SetPostInfo] 后可以再次调用以保存屏幕信息。
+// 从多个goroutine调用是安全的。
+func (c *Control) SaveDevice(s *Session) error {
+	return c.SaveDeviceContext(context.Background(), s)
+}
+
+// SaveDeviceContext 与 [Control.SaveDevice] 相同，但是接受context。
+func (c *Control) SaveDeviceContext(ctx context.Context, s *Session) error {
+	ds, ok := c.store.(DeviceStore)
+	if !ok {
+		return nil
+	}
+	return storageErr(ds.SaveDevice(ctx, s.summary()))
+}
+
+// ListSessions 返回用户所有登录会话的设备摘要，最近登录的在前。
+// 如果数据库不是 [DeviceStore] ，返回 [ErrNotSupported] 。
+// 从多个goroutine调用是安全的。
+func (c *Control) ListSessions(UserName string) ([]DeviceSummary, error) {
+	return c.ListSessionsContext(context.Background(), UserName)
+}
+
+// ListSessionsContext 与 [Control.ListSessions] 相同，但是接受context。
+func (c *Control) ListSessionsContext(ctx context.Context, UserName string) ([]DeviceSummary, error) {
+	ds, ok := c.store.(DeviceStore)
+	if !ok {
+		return nil, ErrNotSupported
+	}
+	ret, err := ds.Devices(ctx, UserName)
+	if err != nil {
+		return nil, storageErr(err)
+	}
+	slices.SortFunc(ret, func(a, b DeviceSummary) int { return b.CreateTime.Compare(a.CreateTime) })
+	return ret, nil
+}
+
+// Revoke 使用户的ID为id的登录会话失效，比如用户在已登录设备页面退出其他设备。
+// 如果登录会话不存在或不属于用户，返回 [ErrSessionNotFound] ，
+// 以免用户使其他用户的登录会话失效。
+// 如果数据库不是 [DeviceStore] ，返回 [ErrNotSupported] 。
+// 从多个goroutine调用是安全的。
+func (c *Control) Revoke(UserName, id string) error {
+	return c.RevokeContext(context.Background(), UserName, id)
+}
+
+// RevokeContext 与 [Control.Revoke] 相同，但是接受context。
+func (c *Control) RevokeContext(ctx context.Context, UserName, id string) error {
+	list, err := c.ListSessionsContext(ctx, UserName)
+	if err != nil {
+		return err
+	}
+	for _, d := range list {
+		if d.ID == id {
+			return storageErr(c.store.Delete(ctx, id))
+		}
+	}
+	return ErrSessionNotFound
+}
+
+// RevokeAllExcept 使用户除了ID为keepID以外的所有登录会话失效，
+// keepID通常是当前登录会话的ID。
+// 如果数据库不是 [DeviceStore] ，返回 [ErrNotSupported] 。
+// 从多个goroutine调用是安全的。
+func (c *Control) RevokeAllExcept(UserName, keepID string) error {
+	return c.RevokeAllExceptContext(context.Background(), UserName, keepID)
+}
+
+// RevokeAllExceptContext 与 [Control.RevokeAllExcept] 相同，但是接受context。
+func (c *Control) RevokeAllExceptContext(ctx context.Context, UserName, keepID string) error {
+	list, err := c.ListSessionsContext(ctx, UserName)
+	if err != nil {
+		return err
+	}
+	for _, d := range list {
+		if d.ID == keepID {
+			continue
+		}
+		if err := c.store.Delete(ctx, d.ID); err != nil {
+			return storageErr(err)
+		}
+	}
+	return nil
+}
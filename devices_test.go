@@ -0,0 +1,15 @@
+package safesession
+
+import "testing"
+
+func TestRevoke(t *testing.T) {
+	if err := c.Revoke("ok", "id"); err != ErrNotSupported {
+		t.Fatal(err)
+	}
+	if _, err := c.ListSessions("ok"); err != ErrNotSupported {
+		t.Fatal(err)
+	}
+	if err := c.RevokeAllExcept("ok", ""); err != ErrNotSupported {
+		t.Fatal(err)
+	}
+}
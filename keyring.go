@@ -0,0 +1,145 @@
+package safesession
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Key 是一个加解密 [Session] 的密钥。
+type Key struct {
+	// ID 是密钥ID，会明文保存在cookie值的开头，
+	// 只能包含字母和数字，应该尽量短。
+	ID string
+	// Sealer 使用这个密钥加解密，
+	// 比如 [NewAESGCM] 或 [FuncSealer] 的返回值。
+	Sealer Sealer
+}
+
+// Keyring 管理多个密钥，用于轮换密钥而不使所有登录会话失效。
+//
+// 新的cookie总是用主密钥加密，
+// 旧的cookie用其中的密钥ID对应的主密钥或已退役的密钥解密。
+// 用已退役的密钥加密的cookie，在下一次通过检查后调用 [Control.SetSession] 时，
+// 会用主密钥重新加密， [Control.Middleware] 会自动这样做。
+//
+// 零值无效，必须使用 [NewKeyring] 初始化。
+// 从多个goroutine调用是安全的。
+type Keyring struct {
+	mu      sync.RWMutex
+	primary Key
+	keys    map[string]Key
+}
+
+// NewKeyring 创建一个 [Keyring] 。
+// primary 是主密钥，retired 是已退役但仍然可以解密的密钥。
+func NewKeyring(primary Key, retired ...Key) (*Keyring, error) {
+	k := &Keyring{keys: make(map[string]Key)}
+	for _, key := range retired {
+		if err := k.add(key); err != nil {
+			return nil, err
+		}
+	}
+	if err := k.add(primary); err != nil {
+		return nil, err
+	}
+	k.primary = primary
+	return k, nil
+}
+
+var errKeyID = errors.New("密钥ID只能包含字母和数字且不能为空")
+var errNilSealer = errors.New("密钥的Sealer不能为nil")
+
+func (k *Keyring) add(key Key) error {
+	if key.ID == "" || strings.IndexFunc(key.ID, func(r rune) bool {
+		return !('a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || '0' <= r && r <= '9')
+	}) != -1 {
+		return errKeyID
+	}
+	if key.Sealer == nil {
+		return errNilSealer
+	}
+	if _, ok := k.keys[key.ID]; ok {
+		return fmt.Errorf("重复的密钥ID %s", key.ID)
+	}
+	k.keys[key.ID] = key
+	return nil
+}
+
+// Rotate 将primary设置为主密钥，原来的主密钥变为已退役的密钥。
+func (k *Keyring) Rotate(primary Key) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if err := k.add(primary); err != nil {
+		return err
+	}
+	k.primary = primary
+	return nil
+}
+
+// Remove 移除已退役的密钥，用它加密的cookie将无法解密。
+// 不能移除主密钥。
+func (k *Keyring) Remove(id string) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if id == k.primary.ID {
+		return errors.New("不能移除主密钥")
+	}
+	delete(k.keys, id)
+	return nil
+}
+
+// Primary 返回主密钥。
+func (k *Keyring) Primary() Key {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.primary
+}
+
+// Key 返回ID为id的密钥。
+func (k *Keyring) Key(id string) (Key, bool) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	key, ok := k.keys[id]
+	return key, ok
+}
+
+// keyIDSep 分隔cookie值中的密钥ID和密文，
+// 不在base32和base64url的字母表中。
+const keyIDSep = "."
+
+// SetKeyring 设置加解密 [Session] 使用的 [Keyring] 。
+// 设置后，没有密钥ID的旧cookie仍然使用 [NewControl] 的encrypt,decrypt
+// 或 [Control.SetSealer] 设置的 [Sealer] 解密。
+// 不能与其他方法并发调用。
+func (c *Control) SetKeyring(k *Keyring) {
+	c.keyring = k
+}
+
+// seal 加密 [Session] 编码后的数据，ad是附加数据，
+// 返回使用的密钥ID，没有使用 [Keyring] 时为""。
+func (c *Control) seal(v, ad []byte) (id string, ciphertext []byte, err error) {
+	if c.keyring == nil {
+		ciphertext, err = c.sealer.Seal(v, ad)
+		return "", ciphertext, err
+	}
+	key := c.keyring.Primary()
+	ciphertext, err = key.Sealer.Seal(v, ad)
+	return key.ID, ciphertext, err
+}
+
+// open 使用密钥ID为id的密钥解密，ad是附加数据。
+func (c *Control) open(id string, ciphertext, ad []byte) ([]byte, error) {
+	if id == "" {
+		return c.sealer.Open(ciphertext, ad)
+	}
+	if c.keyring == nil {
+		return nil, ErrDecrypt
+	}
+	key, ok := c.keyring.Key(id)
+	if !ok {
+		return nil, ErrDecrypt
+	}
+	return key.Sealer.Open(ciphertext, ad)
+}
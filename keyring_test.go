@@ -0,0 +1,104 @@
+package safesession
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testFuncs 返回用前缀模拟加解密的函数。
+func testFuncs(prefix string) (encrypt, decrypt func(string) string) {
+	return func(s string) string { return prefix + s },
+		func(s string) string {
+			v, ok := strings.CutPrefix(s, prefix)
+			if !ok {
+				return ""
+			}
+			return v
+		}
+}
+
+// testKey 返回一个用前缀模拟加密的 [Key] 。
+func testKey(id string) Key {
+	return Key{ID: id, Sealer: FuncSealer(testFuncs(id + ":"))}
+}
+
+func TestKeyring(t *testing.T) {
+	Test = true
+	defer func() { Test = false }()
+	store := &flakyStore{m: make(map[string]time.Time)}
+	encrypt, decrypt := testFuncs("legacy:")
+	c := NewControlWithStore(encrypt, decrypt, time.Hour, 0, nil, store)
+	issue := func() string {
+		s, err := c.NewSessionContext(context.Background(), "", user_agent, "ok")
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := httptest.NewRecorder()
+		c.SetSession(&s, w)
+		return w.Result().Cookies()[0].Value
+	}
+	legacy := issue()
+
+	k, err := NewKeyring(testKey("k1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.SetKeyring(k)
+	v1 := issue()
+	if !strings.HasPrefix(v1, "k1.") {
+		t.Fatalf("got %s, want k1 prefix", v1)
+	}
+	if err := k.Rotate(testKey("k2")); err != nil {
+		t.Fatal(err)
+	}
+	v2 := issue()
+	if !strings.HasPrefix(v2, "k2.") {
+		t.Fatalf("got %s, want k2 prefix", v2)
+	}
+	for _, v := range []string{legacy, v1, v2} {
+		if ok, se := c.decodeSession("session", v); !ok || se.Name != "ok" {
+			t.Fatalf("should decode %s", v)
+		}
+	}
+	// 用已退役的密钥加密的cookie重新响应时使用主密钥。
+	_, se := c.decodeSession("session", v1)
+	w := httptest.NewRecorder()
+	c.SetSession(&se, w)
+	if v := w.Result().Cookies()[0].Value; !strings.HasPrefix(v, "k2.") {
+		t.Fatalf("got %s, want k2 prefix", v)
+	}
+
+	if err := k.Remove("k2"); err == nil {
+		t.Fatal("should not remove primary key")
+	}
+	if err := k.Remove("k1"); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := c.decodeSession("session", v1); ok {
+		t.Fatal("removed key should not decode")
+	}
+	if ok, _ := c.decodeSession("session", "k3."+strings.SplitN(v2, ".", 2)[1]); ok {
+		t.Fatal("unknown key should not decode")
+	}
+	if err := k.Rotate(testKey("k2")); err == nil {
+		t.Fatal("duplicate key ID should fail")
+	}
+	if _, err := NewKeyring(testKey("a.b")); err == nil {
+		t.Fatal("invalid key ID should fail")
+	}
+	if _, err := NewKeyring(Key{ID: "k4"}); err == nil {
+		t.Fatal("nil Sealer should fail")
+	}
+	// 不能写入调用者的切片。
+	retired := make([]Key, 1, 2)
+	retired[0] = testKey("k5")
+	if _, err := NewKeyring(testKey("k6"), retired...); err != nil {
+		t.Fatal(err)
+	}
+	if retired[:2][1].ID != "" {
+		t.Fatal("NewKeyring should not modify retired")
+	}
+}
@@ -0,0 +1,55 @@
+package safesession
+
+import (
+	"time"
+
+	"github.com/qiulaidongfeng/safesession/v3/codec"
+)
+
+// legacySession 是使用 [codec] 编码的旧格式的 [Session] 的字段，
+// 用于解码升级前签发的cookie。
+// 不能修改。
+type legacySession struct {
+	ID            string
+	CreateTime    time.Time
+	Ip            IPInfo
+	Gps           GpsInfo
+	CSRF_TOKEN    string
+	Os, OsVersion string
+	Name          string
+	Device        string
+	Broswer       string
+	Screen        Screen
+	PNum          int64
+}
+
+// isLegacy 报告解密后的cookie值是否是旧格式。
+//
+// 旧格式以base64编码的ID开头，第一个字节是可打印字符，
+// 二进制格式以版本号开头，版本号小于0x20。
+func isLegacy(b []byte) bool {
+	return len(b) != 0 && b[0] >= 0x20
+}
+
+// decodeLegacy 将旧格式的cookie值解码为 [Session] 。
+func (s *Session) decodeLegacy(b []byte) bool {
+	var l legacySession
+	if !codec.Decode(&l, string(b)) {
+		return false
+	}
+	*s = Session{
+		ID:         l.ID,
+		CreateTime: l.CreateTime,
+		Ip:         l.Ip,
+		Gps:        l.Gps,
+		CSRF_TOKEN: l.CSRF_TOKEN,
+		Os:         l.Os,
+		OsVersion:  l.OsVersion,
+		Name:       l.Name,
+		Device:     l.Device,
+		Broswer:    l.Broswer,
+		Screen:     l.Screen,
+		PNum:       l.PNum,
+	}
+	return true
+}
@@ -0,0 +1,47 @@
+package safesession
+
+import (
+	"context"
+	"encoding/base32"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/qiulaidongfeng/safesession/v3/codec"
+)
+
+func TestLegacyCookie(t *testing.T) {
+	Test = true
+	defer func() { Test = false }()
+	store := &flakyStore{m: make(map[string]time.Time)}
+	encrypt, decrypt := testFuncs("legacy:")
+	c := NewControlWithStore(encrypt, decrypt, time.Hour, 0, nil, store)
+	s, err := c.NewSessionContext(context.Background(), "", user_agent, "ok")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 升级前的编码方式。
+	v := codec.Encode(&legacySession{
+		ID: s.ID, CreateTime: s.CreateTime, Ip: s.Ip, Gps: s.Gps,
+		Os: s.Os, OsVersion: s.OsVersion, Name: s.Name, Broswer: s.Broswer,
+		Screen: s.Screen, PNum: s.PNum,
+	})
+	old := base32.StdEncoding.EncodeToString([]byte(encrypt(v)))
+
+	ok, se := c.decodeSession("session", old)
+	if !ok || se.ID != s.ID || se.Name != "ok" || !se.CreateTime.Equal(s.CreateTime) {
+		t.Fatalf("%v %+v", ok, se)
+	}
+	if ok, err := c.Check("", user_agent, &se); !ok {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	c.SetSession(&se, w)
+	b, err := base32.StdEncoding.DecodeString(w.Result().Cookies()[0].Value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := decrypt(string(b)); isLegacy([]byte(p)) || p[0] != binaryVersion {
+		t.Fatal("should re-issue in binary format")
+	}
+}
@@ -0,0 +1,57 @@
+package safesession
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var ErrAbsoluteExpired = errors.New("登录时间过长，请重新登录")
+
+// IssuedAtStore 是保存登录会话首次登录时间的 [Store] ，
+// 用于 [Control.AbsoluteLifetime] 。
+type IssuedAtStore interface {
+	Store
+	// IssuedAt 返回ID的首次登录时间，即Store时的CreateTime，
+	// Update不改变它。
+	// 不知道时返回零值。
+	IssuedAt(ctx context.Context, ID string) (time.Time, error)
+	// SetIssuedAt 在不知道ID的首次登录时间或它晚于t时设置为t，
+	// 用于升级前创建的登录会话，和数据库不是 [RotateStore] 时更换后的ID。
+	// 只能提前，不能推迟首次登录时间。
+	SetIssuedAt(ctx context.Context, ID string, t time.Time) error
+}
+
+// checkLifetime 检查登录会话是否超过 [Control.AbsoluteLifetime] 。
+// 如果数据库是 [IssuedAtStore] ，优先使用数据库保存的首次登录时间，
+// 没有首次登录时间的旧登录会话从现在开始计算，并保存到数据库。
+func (c *Control) checkLifetime(ctx context.Context, s *Session) error {
+	if c.AbsoluteLifetime <= 0 {
+		return nil
+	}
+	is, ok := c.store.(IssuedAtStore)
+	if ok {
+		t, err := is.IssuedAt(ctx, s.ID)
+		if err != nil {
+			return storageErr(err)
+		}
+		if !t.IsZero() {
+			s.IssuedAt = t
+			ok = false
+		}
+	}
+	if s.IssuedAt.IsZero() {
+		s.IssuedAt = time.Now()
+	}
+	if ok {
+		// 数据库不知道首次登录时间，保存下来，
+		// 以免调用者不重新响应cookie时每次检查都从现在开始计算。
+		if err := is.SetIssuedAt(ctx, s.ID, s.IssuedAt); err != nil {
+			return storageErr(err)
+		}
+	}
+	if time.Since(s.IssuedAt) >= c.AbsoluteLifetime {
+		return c.invalidate(ctx, s, ErrAbsoluteExpired)
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package safesession
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var ErrTooManySessions = errors.New("登录设备数量已达上限，请先退出其他设备")
+var ErrEvicted = errors.New("已在其他设备登录，请重新登录")
+
+// SessionLimit 是超过 [Control.MaxSessionsPerUser] 时的处理方式。
+type SessionLimit int
+
+const (
+	// EvictOldest 表示使最久未登录的登录会话失效，
+	// 之后使用它时返回 [ErrEvicted] 。
+	EvictOldest SessionLimit = iota
+	// RejectNew 表示拒绝创建新的登录会话，返回 [ErrTooManySessions] 。
+	RejectNew
+)
+
+// LimitStore 是可以限制每个用户登录会话数量的 [Store] ，
+// 用于 [Control.MaxSessionsPerUser] 。
+type LimitStore interface {
+	Store
+	// StoreLimited 与Store相同，但是同时记录登录会话属于哪个用户，
+	// 并原子地检查用户未过期的登录会话数量。
+	// 如果已经有max个或更多：limit为 [EvictOldest] 时，
+	// 删除CreateTime最早的登录会话直到少于max个，并记录它们被挤下线；
+	// limit为 [RejectNew] 时，返回false和 [ErrTooManySessions] 。
+	StoreLimited(ctx context.Context, ID, UserName string, CreateTime time.Time, max int, limit SessionLimit) (bool, error)
+	// Evicted 报告ID是否因为超过数量限制被删除。
+	Evicted(ctx context.Context, ID string) (bool, error)
+}
+
+// storeNew 存储新创建的登录会话，如果设置了 [Control.MaxSessionsPerUser] ，检查数量限制。
+func (c *Control) storeNew(ctx context.Context, s *Session) (bool, error) {
+	if c.MaxSessionsPerUser <= 0 {
+		ok, err := c.store.Store(ctx, s.ID, s.CreateTime)
+		return ok, storageErr(err)
+	}
+	ls, ok := c.store.(LimitStore)
+	if !ok {
+		return false, ErrNotSupported
+	}
+	ok, err := ls.StoreLimited(ctx, s.ID, s.Name, s.CreateTime, c.MaxSessionsPerUser, c.SessionLimit)
+	if errors.Is(err, ErrTooManySessions) {
+		return false, ErrTooManySessions
+	}
+	return ok, storageErr(err)
+}
+
+// notExistErr 返回登录会话不存在的原因。
+// 如果是因为超过数量限制被删除，返回 [ErrEvicted] ，否则返回nil。
+func (c *Control) notExistErr(ctx context.Context, ID string) error {
+	ls, ok := c.store.(LimitStore)
+	if !ok {
+		return nil
+	}
+	evicted, err := ls.Evicted(ctx, ID)
+	if err != nil {
+		return storageErr(err)
+	}
+	if evicted {
+		return ErrEvicted
+	}
+	return nil
+}
@@ -0,0 +1,21 @@
+package safesession
+
+import (
+	"context"
+	"testing"
+)
+
+func TestSessionLimitNotSupported(t *testing.T) {
+	c.MaxSessionsPerUser = 1
+	defer func() { c.MaxSessionsPerUser = 0 }()
+	if _, err := c.NewSessionContext(context.Background(), "192.168.0.1", user_agent, "ok"); err != ErrNotSupported {
+		t.Fatalf("got %v, want ErrNotSupported", err)
+	}
+	// NewSession不忽略数量限制，而是panic。
+	defer func() {
+		if err := recover(); err != ErrNotSupported {
+			t.Fatalf("got %v, want ErrNotSupported", err)
+		}
+	}()
+	c.NewSession("192.168.0.1", user_agent, "ok")
+}
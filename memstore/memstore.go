@@ -0,0 +1,431 @@
+// Package memstore 实现在内存中保存 [safesession.Session] 的数据库。
+//
+// 适合单实例部署，重启后所有登录会话失效。
+package memstore
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/qiulaidongfeng/safesession/v3"
+)
+
+// Store 在内存中保存 [safesession.Session] 。
+//
+// 零值无效，必须使用 [New] 初始化。
+// 从多个goroutine调用是安全的。
+type Store struct {
+	// Valid 验证 [safesession.Session] 表示的用户登录状态有效，
+	// 可以为nil。
+	Valid func(UserName string, SessionID string) error
+	// MaxSessionsPerUser 限制每个用户的登录会话数量，
+	// 在Valid时检查，超过时最久未登录的登录会话失效，
+	// 使用 [Store.ContextStore] 时，之后检查它返回 [safesession.ErrEvicted] 。
+	// 默认为0，表示不限制。
+	// 推荐使用 [safesession.Control.MaxSessionsPerUser] ，它在创建登录会话时原子地检查。
+	MaxSessionsPerUser int
+
+	mu sync.Mutex
+	// sessions 保存登录会话，键是ID。
+	sessions map[string]*entry
+	// users 是每个用户的登录会话ID索引。
+	users map[string]map[string]struct{}
+	// evicted 记录因为超过数量限制被删除的登录会话ID和它的最近一次登录时间，
+	// 过期后清除。
+	evicted map[string]time.Time
+	maxAge  time.Duration
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+type entry struct {
+	createTime time.Time
+	// issuedAt 是存储时的createTime，即首次登录时间。
+	issuedAt time.Time
+	// user 在第一次调用Valid或SaveDevice，或者在数量限制下创建时设置。
+	user string
+	// device 是设备摘要。
+	device safesession.DeviceSummary
+	// rotatedTo 是宽限期内的旧ID更换后的新ID，
+	// 此时已经不在用户的索引中。
+	rotatedTo string
+}
+
+// New 创建一个 [Store] 。
+// maxAge 应该与 [safesession.NewControl] 的sessionMaxAge相同。
+// 如果sweepInterval大于0，启动一个goroutine每隔sweepInterval清除过期的登录会话，
+// 使用 [Store.Close] 停止。
+func New(maxAge, sweepInterval time.Duration) *Store {
+	s := &Store{
+		sessions: make(map[string]*entry),
+		users:    make(map[string]map[string]struct{}),
+		evicted:  make(map[string]time.Time),
+		maxAge:   maxAge,
+		stop:     make(chan struct{}),
+	}
+	if sweepInterval > 0 {
+		go s.sweeper(sweepInterval)
+	}
+	return s
+}
+
+// DB 返回使用s的 [safesession.DB] 。
+func (s *Store) DB() safesession.DB {
+	return safesession.DB{
+		Store:  s.store,
+		Update: s.update,
+		Delete: s.delete,
+		Exist:  s.exist,
+		Valid:  s.valid,
+	}
+}
+
+// Close 停止清除过期的登录会话的goroutine。
+func (s *Store) Close() {
+	s.stopOnce.Do(func() { close(s.stop) })
+}
+
+func (s *Store) sweeper(interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			s.Sweep()
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// Sweep 清除过期的登录会话。
+func (s *Store) Sweep() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for id, e := range s.sessions {
+		if s.expired(e, now) {
+			s.deleteLocked(id)
+		}
+	}
+	for id, t := range s.evicted {
+		if now.Sub(t) >= s.maxAge {
+			delete(s.evicted, id)
+		}
+	}
+}
+
+// Len 返回保存的登录会话数量，包括还没有清除的过期的登录会话。
+func (s *Store) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.sessions)
+}
+
+// Sessions 返回用户的所有登录会话ID。
+// 只包含调用过Valid或SaveDevice，或者在数量限制下创建的登录会话。
+func (s *Store) Sessions(user string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ret := make([]string, 0, len(s.users[user]))
+	for id := range s.users[user] {
+		ret = append(ret, id)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+func (s *Store) expired(e *entry, now time.Time) bool {
+	return now.Sub(e.createTime) >= s.maxAge
+}
+
+func (s *Store) store(ID string, CreateTime time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if e, ok := s.sessions[ID]; ok && !s.expired(e, time.Now()) {
+		return false
+	}
+	s.deleteLocked(ID)
+	s.sessions[ID] = &entry{createTime: CreateTime, issuedAt: CreateTime}
+	return true
+}
+
+func (s *Store) update(ID string, CreateTime time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if e, ok := s.sessions[ID]; ok && e.rotatedTo == "" {
+		e.createTime = CreateTime
+	}
+}
+
+func (s *Store) delete(ID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.deleteLocked(ID)
+}
+
+func (s *Store) deleteLocked(ID string) {
+	e, ok := s.sessions[ID]
+	if !ok {
+		return
+	}
+	delete(s.sessions, ID)
+	if e.user != "" {
+		delete(s.users[e.user], ID)
+		if len(s.users[e.user]) == 0 {
+			delete(s.users, e.user)
+		}
+	}
+}
+
+func (s *Store) exist(ID string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.sessions[ID]
+	return ok && !s.expired(e, time.Now())
+}
+
+func (s *Store) valid(UserName string, SessionID string) error {
+	if err := s.index(UserName, SessionID); err != nil {
+		return err
+	}
+	if s.Valid != nil {
+		return s.Valid(UserName, SessionID)
+	}
+	return nil
+}
+
+// index 将登录会话加入用户的索引，
+// 并使超过数量限制的最久未登录的其他登录会话失效。
+func (s *Store) index(UserName string, SessionID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.sessions[SessionID]
+	if !ok {
+		return safesession.NotLogined
+	}
+	s.addUserLocked(e, UserName, SessionID)
+	if s.MaxSessionsPerUser <= 0 {
+		return nil
+	}
+	ids := s.users[UserName]
+	for len(ids) > s.MaxSessionsPerUser {
+		oldest := ""
+		for id := range ids {
+			if id == SessionID {
+				continue
+			}
+			if oldest == "" || s.sessions[id].createTime.Before(s.sessions[oldest].createTime) {
+				oldest = id
+			}
+		}
+		s.evictLocked(oldest)
+	}
+	return nil
+}
+
+// addUserLocked 如果登录会话还不在用户的索引中，加入索引。
+func (s *Store) addUserLocked(e *entry, UserName string, SessionID string) {
+	if e.user != "" {
+		return
+	}
+	e.user = UserName
+	if s.users[UserName] == nil {
+		s.users[UserName] = make(map[string]struct{})
+	}
+	s.users[UserName][SessionID] = struct{}{}
+}
+
+func (s *Store) saveDevice(d safesession.DeviceSummary) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.sessions[d.ID]
+	if !ok {
+		return
+	}
+	s.addUserLocked(e, d.UserName, d.ID)
+	e.device = d
+}
+
+func (s *Store) devices(UserName string) []safesession.DeviceSummary {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	var ret []safesession.DeviceSummary
+	for id := range s.users[UserName] {
+		e := s.sessions[id]
+		if s.expired(e, now) {
+			continue
+		}
+		d := e.device
+		d.ID, d.UserName, d.CreateTime = id, UserName, e.createTime
+		ret = append(ret, d)
+	}
+	return ret
+}
+
+func (s *Store) storeLimited(ID, UserName string, CreateTime time.Time, max int, limit safesession.SessionLimit) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	if e, ok := s.sessions[ID]; ok && !s.expired(e, now) {
+		return false, nil
+	}
+	var alive []string
+	for id := range s.users[UserName] {
+		if !s.expired(s.sessions[id], now) {
+			alive = append(alive, id)
+		}
+	}
+	if len(alive) >= max {
+		if limit == safesession.RejectNew {
+			return false, safesession.ErrTooManySessions
+		}
+		sort.Slice(alive, func(i, j int) bool {
+			return s.sessions[alive[i]].createTime.Before(s.sessions[alive[j]].createTime)
+		})
+		for _, id := range alive[:len(alive)-max+1] {
+			s.evictLocked(id)
+		}
+	}
+	s.deleteLocked(ID)
+	e := &entry{createTime: CreateTime, issuedAt: CreateTime}
+	s.sessions[ID] = e
+	s.addUserLocked(e, UserName, ID)
+	return true, nil
+}
+
+func (s *Store) rotate(oldID, newID string, CreateTime time.Time, grace time.Duration) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	old, ok := s.sessions[oldID]
+	if !ok || s.expired(old, now) {
+		return "", safesession.NotLogined
+	}
+	if old.rotatedTo != "" {
+		// 并发的请求在宽限期内更换过ID。
+		e, ok := s.sessions[old.rotatedTo]
+		if !ok || s.expired(e, now) {
+			return "", safesession.NotLogined
+		}
+		e.createTime = CreateTime
+		return old.rotatedTo, nil
+	}
+	if e, ok := s.sessions[newID]; ok && !s.expired(e, now) {
+		return "", nil
+	}
+	s.deleteLocked(newID)
+	e := &entry{createTime: CreateTime, issuedAt: old.issuedAt, device: old.device}
+	s.sessions[newID] = e
+	if old.user != "" {
+		s.addUserLocked(e, old.user, newID)
+		delete(s.users[old.user], oldID)
+	}
+	if grace <= 0 {
+		s.deleteLocked(oldID)
+		return newID, nil
+	}
+	old.rotatedTo = newID
+	// 使旧ID在grace后过期。
+	if t := now.Add(grace - s.maxAge); t.Before(old.createTime) {
+		old.createTime = t
+	}
+	return newID, nil
+}
+
+func (s *Store) issuedAt(ID string) time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if e, ok := s.sessions[ID]; ok {
+		return e.issuedAt
+	}
+	return time.Time{}
+}
+
+// evictLocked 删除因为超过数量限制失效的登录会话，并记录它被挤下线。
+func (s *Store) evictLocked(ID string) {
+	s.evicted[ID] = s.sessions[ID].createTime
+	s.deleteLocked(ID)
+}
+
+func (s *Store) setIssuedAt(ID string, t time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if e, ok := s.sessions[ID]; ok && (e.issuedAt.IsZero() || t.Before(e.issuedAt)) {
+		e.issuedAt = t
+	}
+}
+
+func (s *Store) isEvicted(ID string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.evicted[ID]
+	return ok && time.Since(t) < s.maxAge
+}
+
+// ContextStore 返回使用s的 [safesession.Store] ，
+// 它同时实现了 [safesession.DeviceStore] ， [safesession.LimitStore] ，
+// [safesession.IssuedAtStore] 和 [safesession.RotateStore] 。
+func (s *Store) ContextStore() safesession.Store {
+	return ctxStore{s}
+}
+
+type ctxStore struct {
+	s *Store
+}
+
+func (c ctxStore) Store(ctx context.Context, ID string, CreateTime time.Time) (bool, error) {
+	return c.s.store(ID, CreateTime), nil
+}
+
+func (c ctxStore) Update(ctx context.Context, ID string, CreateTime time.Time) error {
+	c.s.update(ID, CreateTime)
+	return nil
+}
+
+func (c ctxStore) Delete(ctx context.Context, ID string) error {
+	c.s.delete(ID)
+	return nil
+}
+
+func (c ctxStore) Exist(ctx context.Context, ID string) (bool, error) {
+	return c.s.exist(ID), nil
+}
+
+func (c ctxStore) Valid(ctx context.Context, UserName string, SessionID string) error {
+	return c.s.valid(UserName, SessionID)
+}
+
+func (c ctxStore) SaveDevice(ctx context.Context, d safesession.DeviceSummary) error {
+	c.s.saveDevice(d)
+	return nil
+}
+
+func (c ctxStore) Devices(ctx context.Context, UserName string) ([]safesession.DeviceSummary, error) {
+	return c.s.devices(UserName), nil
+}
+
+func (c ctxStore) StoreLimited(ctx context.Context, ID, UserName string, CreateTime time.Time, max int, limit safesession.SessionLimit) (bool, error) {
+	return c.s.storeLimited(ID, UserName, CreateTime, max, limit)
+}
+
+func (c ctxStore) Evicted(ctx context.Context, ID string) (bool, error) {
+	return c.s.isEvicted(ID), nil
+}
+
+func (c ctxStore) IssuedAt(ctx context.Context, ID string) (time.Time, error) {
+	return c.s.issuedAt(ID), nil
+}
+
+func (c ctxStore) Rotate(ctx context.Context, oldID, newID string, CreateTime time.Time, grace time.Duration) (string, error) {
+	return c.s.rotate(oldID, newID, CreateTime, grace)
+}
+
+func (c ctxStore) SetIssuedAt(ctx context.Context, ID string, t time.Time) error {
+	c.s.setIssuedAt(ID, t)
+	return nil
+}
@@ -0,0 +1,319 @@
+package memstore
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/qiulaidongfeng/safesession/v3"
+)
+
+func TestStore(t *testing.T) {
+	s := New(time.Hour, 0)
+	db := s.DB()
+	now := time.Now()
+	if !db.Store("1", now) {
+		t.Fatal("should store")
+	}
+	if db.Store("1", now) {
+		t.Fatal("ID should be duplicate")
+	}
+	if !db.Exist("1") {
+		t.Fatal("should exist")
+	}
+	db.Delete("1")
+	if db.Exist("1") {
+		t.Fatal("should not exist")
+	}
+}
+
+func TestSweep(t *testing.T) {
+	s := New(time.Hour, 0)
+	db := s.DB()
+	db.Store("old", time.Now().Add(-2*time.Hour))
+	db.Store("new", time.Now())
+	if db.Exist("old") {
+		t.Fatal("expired Session should not exist")
+	}
+	if !db.Store("old", time.Now().Add(-2*time.Hour)) {
+		t.Fatal("expired ID should be reusable")
+	}
+	s.Sweep()
+	if s.Len() != 1 || !db.Exist("new") {
+		t.Fatalf("got %d, want 1", s.Len())
+	}
+	db.Update("new", time.Now().Add(-2*time.Hour))
+	s.Sweep()
+	if s.Len() != 0 {
+		t.Fatalf("got %d, want 0", s.Len())
+	}
+}
+
+func TestSweeper(t *testing.T) {
+	s := New(time.Millisecond, time.Millisecond)
+	defer s.Close()
+	s.DB().Store("1", time.Now())
+	for i := 0; s.Len() != 0; i++ {
+		if i == 1000 {
+			t.Fatal("sweeper should clear expired Session")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	s.Close()
+}
+
+func TestMaxSessionsPerUser(t *testing.T) {
+	s := New(time.Hour, 0)
+	s.MaxSessionsPerUser = 2
+	db := s.DB()
+	now := time.Now()
+	for i, id := range []string{"1", "2", "3"} {
+		db.Store(id, now.Add(time.Duration(i)*time.Second))
+		if err := db.Valid("user", id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if db.Exist("1") {
+		t.Fatal("oldest Session should be evicted")
+	}
+	if got := s.Sessions("user"); len(got) != 2 || got[0] != "2" || got[1] != "3" {
+		t.Fatalf("got %v", got)
+	}
+	if ok, err := s.ContextStore().(safesession.LimitStore).Evicted(context.Background(), "1"); !ok || err != nil {
+		t.Fatal("eviction should be recorded", err)
+	}
+}
+
+func TestControl(t *testing.T) {
+	safesession.Test = true
+	defer func() { safesession.Test = false }()
+	s := New(time.Hour, 0)
+	c := safesession.NewControl(func(s string) string { return s }, func(s string) string { return s }, time.Hour, 0, nil, s.DB())
+	se := c.NewSession("", "", "user")
+	w := httptest.NewRecorder()
+	c.SetSession(&se, w)
+	if ok, err, _ := c.CheckLogined("", "", w.Result().Cookies()[0]); !ok {
+		t.Fatal(err)
+	}
+	if got := s.Sessions("user"); len(got) != 1 || got[0] != se.ID {
+		t.Fatalf("got %v", got)
+	}
+}
+
+func TestDevices(t *testing.T) {
+	safesession.Test = true
+	defer func() { safesession.Test = false }()
+	s := New(time.Hour, 0)
+	c := safesession.NewControlWithStore(func(s string) string { return s }, func(s string) string { return s }, time.Hour, 0, nil, s.ContextStore())
+	ctx := context.Background()
+	ua := "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36"
+	var ids []string
+	for range 3 {
+		se, err := c.NewSessionContext(ctx, "", ua, "user")
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, se.ID)
+		time.Sleep(time.Millisecond)
+	}
+	list, err := c.ListSessionsContext(ctx, "user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 3 || list[0].ID != ids[2] || list[0].Os != "Windows" || list[0].Browser != "Chrome" || list[0].UserName != "user" {
+		t.Fatalf("%+v", list)
+	}
+	if err := c.RevokeContext(ctx, "other", ids[0]); err != safesession.ErrSessionNotFound {
+		t.Fatalf("got %v, want ErrSessionNotFound", err)
+	}
+	if err := c.RevokeContext(ctx, "user", ids[0]); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.RevokeAllExceptContext(ctx, "user", ids[1]); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.Sessions("user"); len(got) != 1 || got[0] != ids[1] {
+		t.Fatalf("got %v", got)
+	}
+}
+
+func TestSessionLimit(t *testing.T) {
+	safesession.Test = true
+	defer func() { safesession.Test = false }()
+	s := New(time.Hour, 0)
+	c := safesession.NewControlWithStore(func(s string) string { return s }, func(s string) string { return s }, time.Hour, 0, nil, s.ContextStore())
+	c.MaxSessionsPerUser = 2
+	ctx := context.Background()
+	var cookies []*http.Cookie
+	for range 3 {
+		se, err := c.NewSessionContext(ctx, "", "", "user")
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := httptest.NewRecorder()
+		c.SetSession(&se, w)
+		cookies = append(cookies, w.Result().Cookies()[0])
+		time.Sleep(time.Millisecond)
+	}
+	if ok, err, _ := c.CheckLogined("", "", cookies[0]); ok || err != safesession.ErrEvicted {
+		t.Fatalf("got %v %v, want ErrEvicted", ok, err)
+	}
+	for _, cookie := range cookies[1:] {
+		if ok, err, _ := c.CheckLogined("", "", cookie); !ok {
+			t.Fatal(err)
+		}
+	}
+	c.SessionLimit = safesession.RejectNew
+	if _, err := c.NewSessionContext(ctx, "", "", "user"); err != safesession.ErrTooManySessions {
+		t.Fatalf("got %v, want ErrTooManySessions", err)
+	}
+	// NewSession不忽略SessionLimit，超过数量限制时panic。
+	func() {
+		defer func() {
+			if err := recover(); err != safesession.ErrTooManySessions {
+				t.Fatalf("got %v, want ErrTooManySessions", err)
+			}
+		}()
+		c.NewSession("", "", "user")
+	}()
+	c.SessionLimit = safesession.EvictOldest
+	c.NewSession("", "", "user")
+	if ok, err, _ := c.CheckLogined("", "", cookies[1]); ok || err != safesession.ErrEvicted {
+		t.Fatalf("got %v %v, want ErrEvicted", ok, err)
+	}
+	if _, err := c.NewSessionContext(ctx, "", "", "other"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestIssuedAt(t *testing.T) {
+	s := New(time.Hour, 0)
+	cs := s.ContextStore().(safesession.IssuedAtStore)
+	ctx := context.Background()
+	issued := time.Unix(0, time.Now().UnixNano())
+	cs.Store(ctx, "1", issued)
+	cs.Update(ctx, "1", issued.Add(time.Minute))
+	if got, err := cs.IssuedAt(ctx, "1"); err != nil || !got.Equal(issued) {
+		t.Fatalf("got %v %v, want %v", got, err, issued)
+	}
+	if got, err := cs.IssuedAt(ctx, "2"); err != nil || !got.IsZero() {
+		t.Fatalf("got %v %v, want zero", got, err)
+	}
+	cs.SetIssuedAt(ctx, "1", time.Now())
+	if got, _ := cs.IssuedAt(ctx, "1"); !got.Equal(issued) {
+		t.Fatalf("got %v, SetIssuedAt should not delay known IssuedAt", got)
+	}
+	earlier := issued.Add(-time.Minute)
+	cs.SetIssuedAt(ctx, "1", earlier)
+	if got, _ := cs.IssuedAt(ctx, "1"); !got.Equal(earlier) {
+		t.Fatalf("got %v, want %v", got, earlier)
+	}
+}
+
+func TestRotate(t *testing.T) {
+	safesession.Test = true
+	defer func() { safesession.Test = false }()
+	s := New(time.Hour, 0)
+	c := safesession.NewControlWithStore(func(s string) string { return s }, func(s string) string { return s }, time.Hour, 0, nil, s.ContextStore())
+	c.RotationInterval = time.Millisecond
+	ctx := context.Background()
+	se, err := c.NewSessionContext(ctx, "", "", "user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	old, oldID := se, se.ID
+	time.Sleep(2 * time.Millisecond)
+	if ok, err := c.Check("", "", &se); !ok {
+		t.Fatal(err)
+	}
+	if se.ID == oldID || !se.IssuedAt.Equal(old.IssuedAt) {
+		t.Fatalf("got %s, want new ID", se.ID)
+	}
+	// 宽限期内携带旧ID的并发请求得到相同的新ID。
+	if ok, err := c.Check("", "", &old); !ok || old.ID != se.ID {
+		t.Fatal(err, old.ID)
+	}
+	list, err := c.ListSessionsContext(ctx, "user")
+	if err != nil || len(list) != 1 || list[0].ID != se.ID {
+		t.Fatalf("%+v %v", list, err)
+	}
+	if err := c.Rotate(&se, httptest.NewRecorder()); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := s.ContextStore().Exist(ctx, oldID); !ok {
+		t.Fatal("rotated ID should exist in grace period")
+	}
+	if ok, _ := s.ContextStore().Exist(ctx, list[0].ID); ok {
+		t.Fatal("Rotate should delete old ID")
+	}
+
+	cs := s.ContextStore().(safesession.RotateStore)
+	cs.Store(ctx, "1", time.Now())
+	if id, err := cs.Rotate(ctx, "1", "2", time.Now(), time.Millisecond); id != "2" || err != nil {
+		t.Fatal(id, err)
+	}
+	time.Sleep(2 * time.Millisecond)
+	if ok, _ := cs.Exist(ctx, "1"); ok {
+		t.Fatal("rotated ID should expire after grace period")
+	}
+	if _, err := cs.Rotate(ctx, "1", "3", time.Now(), 0); err != safesession.NotLogined {
+		t.Fatal(err)
+	}
+}
+
+type deviceLimitIssuedAtStore interface {
+	safesession.DeviceStore
+	safesession.LimitStore
+	safesession.IssuedAtStore
+}
+
+// noRotateStore 隐藏Rotate方法，用于测试数据库不是RotateStore时更换ID。
+type noRotateStore struct {
+	deviceLimitIssuedAtStore
+}
+
+func TestRotateFallback(t *testing.T) {
+	safesession.Test = true
+	defer func() { safesession.Test = false }()
+	s := New(time.Hour, 0)
+	cs := s.ContextStore().(ctxStore)
+	c := safesession.NewControlWithStore(func(s string) string { return s }, func(s string) string { return s }, time.Hour, 0, nil, noRotateStore{cs})
+	c.RotationInterval = time.Millisecond
+	c.MaxSessionsPerUser = 1
+	ctx := context.Background()
+	se, err := c.NewSessionContext(ctx, "", "", "user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	old, oldID := se, se.ID
+	issued, _ := cs.IssuedAt(ctx, oldID)
+	time.Sleep(2 * time.Millisecond)
+	if ok, err := c.Check("", "", &se); !ok || se.ID == oldID {
+		t.Fatal(err, se.ID)
+	}
+	// 新ID继承首次登录时间。
+	if got, err := cs.IssuedAt(ctx, se.ID); err != nil || !got.Equal(issued) {
+		t.Fatalf("got %v %v, want %v", got, err, issued)
+	}
+	if ok, _ := cs.Exist(ctx, oldID); !ok {
+		t.Fatal("rotated ID should exist in grace period")
+	}
+	// 宽限期内携带旧ID的请求继续使用旧ID，不得到新ID。
+	if ok, err := c.Check("", "", &old); !ok || old.ID != oldID {
+		t.Fatal(err, old.ID)
+	}
+	// 旧ID在宽限期内仍然计数，不会使新ID超过数量限制。
+	list, err := c.ListSessionsContext(ctx, "user")
+	if err != nil || len(list) != 2 {
+		t.Fatalf("%+v %v", list, err)
+	}
+	rotated := se.ID
+	if err := c.Rotate(&se, httptest.NewRecorder()); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := cs.Exist(ctx, rotated); ok {
+		t.Fatal("Rotate should delete old ID")
+	}
+}
@@ -0,0 +1,103 @@
+package safesession
+
+import (
+	"context"
+	"errors"
+	"net/http"
+)
+
+var NotLogined = errors.New("未登录，请先登录")
+
+// sessionKey 是在请求的context中保存 [ctxSession] 的键。
+type sessionKey struct{}
+
+// ctxSession 是保存在请求的context中的登录会话。
+type ctxSession struct {
+	s      *Session
+	stepUp bool
+}
+
+// Middleware 返回一个检查登录会话的 [http.Handler] 。
+//
+// 它从请求读取cookie并调用 [Control.CheckLogined] ，
+// 通过检查时重新响应cookie以更新最近一次登录时间，
+// 并将 [Session] 保存到请求的context，可以使用 [FromContext] 获取；
+// 需要二次验证时保留cookie，同样保存 [Session] ，
+// 但是 [FromContext] 返回false，需要使用 [StepUpFromContext] 获取，以便引导用户进行二次验证；
+// 未通过检查时删除cookie，并调用 [Control.Unauthorized] ，
+// 如果没有设置，响应401；
+// 数据库返回错误时保留cookie，同样调用 [Control.Unauthorized] ，
+// 如果没有设置，响应503。
+func (c *Control) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cookie, err := c.readCookie(r)
+		if err != nil {
+			c.unauthorized(w, r, NotLogined)
+			return
+		}
+		ok, err, se := c.CheckLoginedContext(r.Context(), c.ClientIP(r), r.UserAgent(), cookie)
+		if errors.Is(err, ErrStorageUnavailable) {
+			// 存储暂时不可用时登录会话可能仍然有效，
+			// 所以保留cookie。
+			c.unauthorized(w, r, err)
+			return
+		}
+		if err == NeedStepUp {
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), sessionKey{}, ctxSession{s: &se, stepUp: true})))
+			return
+		}
+		if !ok {
+			// 登录会话不存在或者未通过检查，
+			// cookie都已经无效。
+			c.ClearSession(w)
+			if err == nil {
+				err = NotLogined
+			}
+			c.unauthorized(w, r, err)
+			return
+		}
+		// 失败时原cookie仍然有效，比如调小了MaxClaimsSize，
+		// 只是不更新cookie的有效期。
+		_ = c.SetSession(&se, w)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), sessionKey{}, ctxSession{s: &se})))
+	})
+}
+
+// unauthorized 响应未登录。
+func (c *Control) unauthorized(w http.ResponseWriter, r *http.Request, err error) {
+	if c.Unauthorized != nil {
+		c.Unauthorized(w, r, err)
+		return
+	}
+	code := http.StatusUnauthorized
+	if errors.Is(err, ErrStorageUnavailable) {
+		code = http.StatusServiceUnavailable
+	}
+	http.Error(w, err.Error(), code)
+}
+
+// FromContext 返回 [Control.Middleware] 保存在ctx的通过检查的 [Session] 。
+// 需要二次验证的Session返回nil和false，使用 [StepUpFromContext] 获取。
+func FromContext(ctx context.Context) (*Session, bool) {
+	s, ok := ctx.Value(sessionKey{}).(ctxSession)
+	if !ok || s.stepUp {
+		return nil, false
+	}
+	return s.s, true
+}
+
+// StepUpFromContext 返回 [Control.Middleware] 保存在ctx的需要二次验证的 [Session] ，
+// 二次验证通过后应该调用 [Control.CompleteStepUp] 。
+func StepUpFromContext(ctx context.Context) (*Session, bool) {
+	s, ok := ctx.Value(sessionKey{}).(ctxSession)
+	if !ok || !s.stepUp {
+		return nil, false
+	}
+	return s.s, true
+}
+
+// StepUpRequired 报告 [Control.Middleware] 保存在ctx的 [Session] 是否需要二次验证。
+func StepUpRequired(ctx context.Context) bool {
+	s, _ := ctx.Value(sessionKey{}).(ctxSession)
+	return s.stepUp
+}
@@ -0,0 +1,99 @@
+package safesession
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMiddleware(t *testing.T) {
+	defer func(n int) { delete_num = n }(delete_num)
+	h := c.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s, ok := FromContext(r.Context())
+		if !ok {
+			t.Fatal("should have Session")
+		}
+		w.Write([]byte(s.Name))
+	}))
+	s := c.NewSession("192.168.0.3", user_agent, "ok")
+	w := httptest.NewRecorder()
+	c.SetSession(&s, w)
+	cookie := w.Result().Cookies()[0]
+
+	t.Run("ok", func(t *testing.T) {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = "192.168.0.3:1234"
+		r.Header.Set("User-Agent", user_agent)
+		r.AddCookie(cookie)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != http.StatusOK || w.Body.String() != "ok" {
+			t.Fatalf("got %d %s", w.Code, w.Body.String())
+		}
+		cs := w.Result().Cookies()
+		if len(cs) != 1 || cs[0].MaxAge != 43200 {
+			t.Fatalf("should re-issue cookie, got %+v", cs)
+		}
+	})
+	t.Run("no cookie", func(t *testing.T) {
+		r := httptest.NewRequest("GET", "/", nil)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != http.StatusUnauthorized {
+			t.Fatalf("got %d, want 401", w.Code)
+		}
+	})
+	t.Run("stolen", func(t *testing.T) {
+		var got error
+		original := c.Unauthorized
+		c.Unauthorized = func(w http.ResponseWriter, r *http.Request, err error) {
+			got = err
+			w.WriteHeader(http.StatusForbidden)
+		}
+		defer func() { c.Unauthorized = original }()
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = "192.168.0.3:1234"
+		r.AddCookie(cookie)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != http.StatusForbidden || got != MayStolen {
+			t.Fatalf("got %d %v", w.Code, got)
+		}
+		cs := w.Result().Cookies()
+		if len(cs) != 1 || cs[0].MaxAge >= 0 {
+			t.Fatalf("should delete cookie, got %+v", cs)
+		}
+	})
+	t.Run("step up", func(t *testing.T) {
+		p := NewWeightedPolicy()
+		p.StepUp = 1
+		p.Deny = hardWeight
+		c.RiskPolicy = p
+		defer func() { c.RiskPolicy = nil }()
+		h := c.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if _, ok := FromContext(r.Context()); ok {
+				t.Fatal("FromContext should not return Session that needs step-up")
+			}
+			if s, ok := StepUpFromContext(r.Context()); !ok || !StepUpRequired(r.Context()) {
+				t.Fatal("should need step-up")
+			} else {
+				w.Write([]byte(s.Name))
+			}
+		}))
+		// 上一个子测试删除了登录会话。
+		s := c.NewSession("192.168.0.3", user_agent, "ok")
+		w := httptest.NewRecorder()
+		c.SetSession(&s, w)
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = "192.168.0.3:1234"
+		// 只有系统版本不一致。
+		r.Header.Set("User-Agent", strings.Replace(user_agent, "Android 6.0", "Android 7.0", 1))
+		r.AddCookie(w.Result().Cookies()[0])
+		w = httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != http.StatusOK || w.Body.String() != "ok" {
+			t.Fatalf("got %d %s", w.Code, w.Body.String())
+		}
+	})
+}
@@ -0,0 +1,252 @@
+package redisstore
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeRedis 是在进程内运行的Redis服务器，
+// 只实现测试需要的命令。
+type fakeRedis struct {
+	mu      sync.Mutex
+	strings map[string]string
+	sets    map[string]map[string]struct{}
+	hashes  map[string]map[string]string
+	expire  map[string]time.Time
+	// delay 是下一个回复的延迟，使用后重置为0。
+	delay time.Duration
+	ln    net.Listener
+}
+
+func newFakeRedis(t *testing.T) *fakeRedis {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeRedis{
+		strings: make(map[string]string),
+		sets:    make(map[string]map[string]struct{}),
+		hashes:  make(map[string]map[string]string),
+		expire:  make(map[string]time.Time),
+		ln:      ln,
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(c)
+		}
+	}()
+	return f
+}
+
+func (f *fakeRedis) addr() string { return f.ln.Addr().String() }
+
+func (f *fakeRedis) serve(c net.Conn) {
+	defer c.Close()
+	r := bufio.NewReader(c)
+	for {
+		v, err := readReply(r)
+		if err != nil {
+			return
+		}
+		items := v.([]any)
+		args := make([]string, len(items))
+		for i := range items {
+			args[i] = items[i].(string)
+		}
+		f.mu.Lock()
+		reply := f.do(args)
+		delay := f.delay
+		f.delay = 0
+		f.mu.Unlock()
+		time.Sleep(delay)
+		if _, err := io.WriteString(c, reply); err != nil {
+			return
+		}
+	}
+}
+
+// gc 清除过期的键。
+func (f *fakeRedis) gc(k string) {
+	if t, ok := f.expire[k]; ok && !time.Now().Before(t) {
+		f.del(k)
+	}
+}
+
+func (f *fakeRedis) del(k string) bool {
+	ok := f.exists(k)
+	delete(f.strings, k)
+	delete(f.sets, k)
+	delete(f.hashes, k)
+	delete(f.expire, k)
+	return ok
+}
+
+func (f *fakeRedis) exists(k string) bool {
+	_, ok1 := f.strings[k]
+	_, ok2 := f.sets[k]
+	_, ok3 := f.hashes[k]
+	return ok1 || ok2 || ok3
+}
+
+func bulk(s string) string { return fmt.Sprintf("$%d\r\n%s\r\n", len(s), s) }
+
+func integer(n int) string { return fmt.Sprintf(":%d\r\n", n) }
+
+func (f *fakeRedis) do(args []string) string {
+	for _, k := range args[1:] {
+		f.gc(k)
+	}
+	switch strings.ToUpper(args[0]) {
+	case "SET":
+		k, v := args[1], args[2]
+		_, exist := f.strings[k]
+		var px time.Duration
+		for i := 3; i < len(args); i++ {
+			switch strings.ToUpper(args[i]) {
+			case "NX":
+				if exist {
+					return "$-1\r\n"
+				}
+			case "XX":
+				if !exist {
+					return "$-1\r\n"
+				}
+			case "PX":
+				ms, _ := strconv.Atoi(args[i+1])
+				px = time.Duration(ms) * time.Millisecond
+				i++
+			}
+		}
+		f.del(k)
+		f.strings[k] = v
+		if px > 0 {
+			f.expire[k] = time.Now().Add(px)
+		}
+		return "+OK\r\n"
+	case "GET":
+		v, ok := f.strings[args[1]]
+		if !ok {
+			return "$-1\r\n"
+		}
+		return bulk(v)
+	case "MGET":
+		ret := fmt.Sprintf("*%d\r\n", len(args)-1)
+		for _, k := range args[1:] {
+			if v, ok := f.strings[k]; ok {
+				ret += bulk(v)
+			} else {
+				ret += "$-1\r\n"
+			}
+		}
+		return ret
+	case "DEL":
+		n := 0
+		for _, k := range args[1:] {
+			if f.del(k) {
+				n++
+			}
+		}
+		return integer(n)
+	case "EXISTS":
+		n := 0
+		for _, k := range args[1:] {
+			if f.exists(k) {
+				n++
+			}
+		}
+		return integer(n)
+	case "PEXPIRE":
+		k := args[1]
+		if !f.exists(k) {
+			return integer(0)
+		}
+		ms, _ := strconv.Atoi(args[2])
+		f.expire[k] = time.Now().Add(time.Duration(ms) * time.Millisecond)
+		return integer(1)
+	case "SADD":
+		s := f.sets[args[1]]
+		if s == nil {
+			s = make(map[string]struct{})
+			f.sets[args[1]] = s
+		}
+		n := 0
+		for _, m := range args[2:] {
+			if _, ok := s[m]; !ok {
+				s[m] = struct{}{}
+				n++
+			}
+		}
+		return integer(n)
+	case "SREM":
+		s := f.sets[args[1]]
+		n := 0
+		for _, m := range args[2:] {
+			if _, ok := s[m]; ok {
+				delete(s, m)
+				n++
+			}
+		}
+		if len(s) == 0 {
+			f.del(args[1])
+		}
+		return integer(n)
+	case "SMEMBERS":
+		s := f.sets[args[1]]
+		ret := fmt.Sprintf("*%d\r\n", len(s))
+		for m := range s {
+			ret += bulk(m)
+		}
+		return ret
+	case "HSET":
+		h := f.hashes[args[1]]
+		if h == nil {
+			h = make(map[string]string)
+			f.hashes[args[1]] = h
+		}
+		n := 0
+		for i := 2; i+1 < len(args); i += 2 {
+			if _, ok := h[args[i]]; !ok {
+				n++
+			}
+			h[args[i]] = args[i+1]
+		}
+		return integer(n)
+	case "HMGET":
+		h := f.hashes[args[1]]
+		ret := fmt.Sprintf("*%d\r\n", len(args)-2)
+		for _, field := range args[2:] {
+			if v, ok := h[field]; ok {
+				ret += bulk(v)
+			} else {
+				ret += "$-1\r\n"
+			}
+		}
+		return ret
+	case "HDEL":
+		h := f.hashes[args[1]]
+		n := 0
+		for _, field := range args[2:] {
+			if _, ok := h[field]; ok {
+				delete(h, field)
+				n++
+			}
+		}
+		if len(h) == 0 {
+			f.del(args[1])
+		}
+		return integer(n)
+	}
+	return "-ERR unknown command '" + args[0] + "'\r\n"
+}
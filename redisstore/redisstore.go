@@ -0,0 +1,362 @@
+// Package redisstore 实现使用Redis保存 [safesession.Session] 的数据库。
+//
+// 每个登录会话保存为一个键，值是最近一次登录时间的Unix纳秒时间戳，
+// 过期时间等于sessionMaxAge减去距离最近一次登录的时间，所以过期的登录会话由Redis自动清除。
+// 每个用户的登录会话ID保存在一个集合中，用于 [Store.Sessions] 和限制登录会话数量。
+// 每个用户的登录会话的设备摘要保存在一个哈希表中，字段是登录会话ID，值是JSON编码的 [safesession.DeviceSummary] 。
+package redisstore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/qiulaidongfeng/safesession/v3"
+)
+
+// Client 发送Redis命令。
+//
+// 回复的类型：简单字符串和批量字符串是string，整数是int64，
+// 数组是[]any，空值是nil，Redis返回的错误作为error返回。
+// [Conn] 实现了Client，其他客户端可以简单包装一下实现，比如
+//
+//	func (c wrap) Do(ctx context.Context, args ...string) (any, error) {
+//		a := make([]any, len(args))
+//		for i := range args {
+//			a[i] = args[i]
+//		}
+//		v, err := c.rdb.Do(ctx, a...).Result()
+//		if err == redis.Nil {
+//			err = nil
+//		}
+//		return v, err
+//	}
+//
+// 从多个goroutine调用里面的方法应该是安全的。
+type Client interface {
+	Do(ctx context.Context, args ...string) (any, error)
+}
+
+// Store 使用Redis保存 [safesession.Session] 。
+//
+// 零值无效，必须使用 [New] 初始化。
+// 从多个goroutine调用是安全的。
+type Store struct {
+	// Valid 验证 [safesession.Session] 表示的用户登录状态有效，
+	// 可以为nil。
+	Valid func(UserName string, SessionID string) error
+	// OnError 在 [safesession.DB] 的操作发生Redis错误时被调用，
+	// 可以为nil。
+	OnError func(err error)
+	// MaxSessionsPerUser 限制每个用户的登录会话数量，
+	// 超过时最久未登录的登录会话失效。
+	// 默认为0，表示不限制。
+	MaxSessionsPerUser int
+
+	c      Client
+	prefix string
+	maxAge time.Duration
+}
+
+// New 创建一个 [Store] 。
+// prefix 是所有键的前缀，为空时使用"safesession:"。
+// maxAge 应该与 [safesession.NewControl] 的sessionMaxAge相同。
+func New(c Client, prefix string, maxAge time.Duration) *Store {
+	if prefix == "" {
+		prefix = "safesession:"
+	}
+	return &Store{c: c, prefix: prefix, maxAge: maxAge}
+}
+
+// DB 返回使用s的 [safesession.DB] 。
+//
+// 因为 [safesession.DB] 的操作不能返回错误，
+// 发生Redis错误时调用 [Store.OnError] ，
+// 并且Store返回true以免无限重试，Exist返回false。
+func (s *Store) DB() safesession.DB {
+	return safesession.DB{
+		Store: func(ID string, CreateTime time.Time) bool {
+			ok, err := s.store(context.Background(), ID, CreateTime)
+			if err != nil {
+				s.onError(err)
+				return true
+			}
+			return ok
+		},
+		Update: func(ID string, CreateTime time.Time) {
+			s.onError(s.update(context.Background(), ID, CreateTime))
+		},
+		Delete: func(ID string) {
+			s.onError(s.delete(context.Background(), ID))
+		},
+		Exist: func(ID string) bool {
+			ok, err := s.exist(context.Background(), ID)
+			s.onError(err)
+			return ok
+		},
+		Valid: func(UserName string, SessionID string) error {
+			if err := s.index(context.Background(), UserName, SessionID); err != nil {
+				s.onError(err)
+			}
+			if s.Valid != nil {
+				return s.Valid(UserName, SessionID)
+			}
+			return nil
+		},
+	}
+}
+
+func (s *Store) onError(err error) {
+	if err != nil && s.OnError != nil {
+		s.OnError(err)
+	}
+}
+
+func (s *Store) key(ID string) string {
+	return s.prefix + "session:" + ID
+}
+
+func (s *Store) userKey(UserName string) string {
+	return s.prefix + "user:" + UserName
+}
+
+func (s *Store) deviceKey(UserName string) string {
+	return s.prefix + "device:" + UserName
+}
+
+// ttl 返回最近一次登录时间为t的登录会话还有多少毫秒过期，至少为1。
+func (s *Store) ttl(t time.Time) string {
+	ms := (s.maxAge - time.Since(t)).Milliseconds()
+	if ms < 1 {
+		ms = 1
+	}
+	return strconv.FormatInt(ms, 10)
+}
+
+func (s *Store) store(ctx context.Context, ID string, CreateTime time.Time) (bool, error) {
+	v, err := s.c.Do(ctx, "SET", s.key(ID), strconv.FormatInt(CreateTime.UnixNano(), 10), "NX", "PX", s.ttl(CreateTime))
+	return v != nil, err
+}
+
+func (s *Store) update(ctx context.Context, ID string, CreateTime time.Time) error {
+	_, err := s.c.Do(ctx, "SET", s.key(ID), strconv.FormatInt(CreateTime.UnixNano(), 10), "XX", "PX", s.ttl(CreateTime))
+	return err
+}
+
+func (s *Store) delete(ctx context.Context, ID string) error {
+	// 用户的集合中的ID在 [Store.Sessions] 时清除。
+	_, err := s.c.Do(ctx, "DEL", s.key(ID))
+	return err
+}
+
+func (s *Store) exist(ctx context.Context, ID string) (bool, error) {
+	v, err := s.c.Do(ctx, "EXISTS", s.key(ID))
+	n, _ := v.(int64)
+	return n == 1, err
+}
+
+// index 将登录会话加入用户的集合，
+// 并使超过数量限制的最久未登录的其他登录会话失效。
+func (s *Store) index(ctx context.Context, UserName string, SessionID string) error {
+	k := s.userKey(UserName)
+	if _, err := s.c.Do(ctx, "SADD", k, SessionID); err != nil {
+		return err
+	}
+	if err := s.expireUser(ctx, UserName); err != nil {
+		return err
+	}
+	if s.MaxSessionsPerUser <= 0 {
+		return nil
+	}
+	ids, times, err := s.sessions(ctx, UserName)
+	if err != nil {
+		return err
+	}
+	for len(ids) > s.MaxSessionsPerUser {
+		oldest := -1
+		for i := range ids {
+			if ids[i] != SessionID && (oldest == -1 || times[i] < times[oldest]) {
+				oldest = i
+			}
+		}
+		if err := s.delete(ctx, ids[oldest]); err != nil {
+			return err
+		}
+		if _, err := s.c.Do(ctx, "SREM", k, ids[oldest]); err != nil {
+			return err
+		}
+		if _, err := s.c.Do(ctx, "HDEL", s.deviceKey(UserName), ids[oldest]); err != nil {
+			return err
+		}
+		ids = append(ids[:oldest], ids[oldest+1:]...)
+		times = append(times[:oldest], times[oldest+1:]...)
+	}
+	return nil
+}
+
+// expireUser 延长用户的集合和哈希表的过期时间。
+func (s *Store) expireUser(ctx context.Context, UserName string) error {
+	ms := strconv.FormatInt(s.maxAge.Milliseconds(), 10)
+	for _, k := range []string{s.userKey(UserName), s.deviceKey(UserName)} {
+		if _, err := s.c.Do(ctx, "PEXPIRE", k, ms); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Store) saveDevice(ctx context.Context, d safesession.DeviceSummary) error {
+	b, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+	if _, err := s.c.Do(ctx, "HSET", s.deviceKey(d.UserName), d.ID, string(b)); err != nil {
+		return err
+	}
+	if _, err := s.c.Do(ctx, "SADD", s.userKey(d.UserName), d.ID); err != nil {
+		return err
+	}
+	return s.expireUser(ctx, d.UserName)
+}
+
+func (s *Store) devices(ctx context.Context, UserName string) ([]safesession.DeviceSummary, error) {
+	ids, times, err := s.sessions(ctx, UserName)
+	if err != nil || len(ids) == 0 {
+		return nil, err
+	}
+	args := append([]string{"HMGET", s.deviceKey(UserName)}, ids...)
+	v, err := s.c.Do(ctx, args...)
+	if err != nil {
+		return nil, err
+	}
+	values, ok := v.([]any)
+	if !ok || len(values) != len(ids) {
+		return nil, errReply
+	}
+	ret := make([]safesession.DeviceSummary, 0, len(ids))
+	for i, id := range ids {
+		var d safesession.DeviceSummary
+		// 只调用过Valid的登录会话没有设备摘要。
+		if v, ok := values[i].(string); ok {
+			if err := json.Unmarshal([]byte(v), &d); err != nil {
+				return nil, err
+			}
+		}
+		d.ID, d.UserName, d.CreateTime = id, UserName, time.Unix(0, times[i])
+		ret = append(ret, d)
+	}
+	return ret, nil
+}
+
+// Sessions 返回用户的所有登录会话ID。
+// 只包含调用过Valid或保存过设备摘要的登录会话。
+func (s *Store) Sessions(ctx context.Context, UserName string) ([]string, error) {
+	ids, _, err := s.sessions(ctx, UserName)
+	sort.Strings(ids)
+	return ids, err
+}
+
+var errReply = errors.New("redisstore: 意外的回复类型")
+
+// sessions 返回用户的所有登录会话ID和最近一次登录时间，
+// 并从集合和哈希表中清除已经不存在的登录会话。
+func (s *Store) sessions(ctx context.Context, UserName string) ([]string, []int64, error) {
+	k := s.userKey(UserName)
+	v, err := s.c.Do(ctx, "SMEMBERS", k)
+	if err != nil {
+		return nil, nil, err
+	}
+	members, ok := v.([]any)
+	if !ok {
+		return nil, nil, errReply
+	}
+	if len(members) == 0 {
+		return nil, nil, nil
+	}
+	args := make([]string, 0, len(members)+1)
+	args = append(args, "MGET")
+	for _, m := range members {
+		id, _ := m.(string)
+		args = append(args, s.key(id))
+	}
+	v, err = s.c.Do(ctx, args...)
+	if err != nil {
+		return nil, nil, err
+	}
+	values, ok := v.([]any)
+	if !ok || len(values) != len(members) {
+		return nil, nil, errReply
+	}
+	var ids []string
+	var times []int64
+	for i, m := range members {
+		id, _ := m.(string)
+		t, ok := values[i].(string)
+		if !ok {
+			// 登录会话已经不存在
+			if _, err := s.c.Do(ctx, "SREM", k, id); err != nil {
+				return nil, nil, err
+			}
+			if _, err := s.c.Do(ctx, "HDEL", s.deviceKey(UserName), id); err != nil {
+				return nil, nil, err
+			}
+			continue
+		}
+		n, err := strconv.ParseInt(t, 10, 64)
+		if err != nil {
+			return nil, nil, err
+		}
+		ids = append(ids, id)
+		times = append(times, n)
+	}
+	return ids, times, nil
+}
+
+// ContextStore 返回使用s的 [safesession.Store] ，
+// 它同时实现了 [safesession.DeviceStore] ，
+// Redis错误作为错误返回，不调用 [Store.OnError] 。
+func (s *Store) ContextStore() safesession.Store {
+	return ctxStore{s}
+}
+
+type ctxStore struct {
+	s *Store
+}
+
+func (c ctxStore) Store(ctx context.Context, ID string, CreateTime time.Time) (bool, error) {
+	return c.s.store(ctx, ID, CreateTime)
+}
+
+func (c ctxStore) Update(ctx context.Context, ID string, CreateTime time.Time) error {
+	return c.s.update(ctx, ID, CreateTime)
+}
+
+func (c ctxStore) Delete(ctx context.Context, ID string) error {
+	return c.s.delete(ctx, ID)
+}
+
+func (c ctxStore) Exist(ctx context.Context, ID string) (bool, error) {
+	return c.s.exist(ctx, ID)
+}
+
+func (c ctxStore) Valid(ctx context.Context, UserName string, SessionID string) error {
+	if err := c.s.index(ctx, UserName, SessionID); err != nil {
+		return fmt.Errorf("%w: %w", safesession.ErrStorageUnavailable, err)
+	}
+	if c.s.Valid != nil {
+		return c.s.Valid(UserName, SessionID)
+	}
+	return nil
+}
+
+func (c ctxStore) SaveDevice(ctx context.Context, d safesession.DeviceSummary) error {
+	return c.s.saveDevice(ctx, d)
+}
+
+func (c ctxStore) Devices(ctx context.Context, UserName string) ([]safesession.DeviceSummary, error) {
+	return c.s.devices(ctx, UserName)
+}
@@ -0,0 +1,199 @@
+package redisstore
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/qiulaidongfeng/safesession/v3"
+)
+
+func newStore(t *testing.T, maxAge time.Duration) *Store {
+	f := newFakeRedis(t)
+	c, err := Dial(context.Background(), "tcp", f.addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	s := New(c, "", maxAge)
+	s.OnError = func(err error) { t.Fatal(err) }
+	return s
+}
+
+func TestStore(t *testing.T) {
+	db := newStore(t, time.Hour).DB()
+	now := time.Now()
+	if !db.Store("1", now) {
+		t.Fatal("should store")
+	}
+	if db.Store("1", now) {
+		t.Fatal("ID should be duplicate")
+	}
+	if !db.Exist("1") {
+		t.Fatal("should exist")
+	}
+	db.Delete("1")
+	if db.Exist("1") {
+		t.Fatal("should not exist")
+	}
+}
+
+func TestTTL(t *testing.T) {
+	db := newStore(t, 50*time.Millisecond).DB()
+	db.Store("1", time.Now())
+	db.Store("2", time.Now())
+	time.Sleep(30 * time.Millisecond)
+	db.Update("2", time.Now())
+	time.Sleep(30 * time.Millisecond)
+	if db.Exist("1") {
+		t.Fatal("expired Session should not exist")
+	}
+	if !db.Exist("2") {
+		t.Fatal("updated Session should exist")
+	}
+}
+
+func TestMaxSessionsPerUser(t *testing.T) {
+	s := newStore(t, time.Hour)
+	s.MaxSessionsPerUser = 2
+	db := s.DB()
+	now := time.Now()
+	for i, id := range []string{"1", "2", "3"} {
+		db.Store(id, now.Add(time.Duration(i)*time.Second))
+		if err := db.Valid("user", id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if db.Exist("1") {
+		t.Fatal("oldest Session should be evicted")
+	}
+	db.Delete("3")
+	got, err := s.Sessions(context.Background(), "user")
+	if err != nil || len(got) != 1 || got[0] != "2" {
+		t.Fatalf("got %v %v", got, err)
+	}
+}
+
+func TestError(t *testing.T) {
+	s := newStore(t, time.Hour)
+	_, err := s.c.Do(context.Background(), "NOPE")
+	var e Error
+	if !errors.As(err, &e) {
+		t.Fatalf("got %v, want Error", err)
+	}
+	// 连接在错误后仍然可用。
+	if _, err := s.c.Do(context.Background(), "EXISTS", "x"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	f := newFakeRedis(t)
+	c, err := Dial(context.Background(), "tcp", f.addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	ctx := context.Background()
+	c.Do(ctx, "SET", "a", "1")
+	f.mu.Lock()
+	f.delay = 100 * time.Millisecond
+	f.mu.Unlock()
+	tctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if _, err := c.Do(tctx, "EXISTS", "a"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got %v, want DeadlineExceeded", err)
+	}
+	// 不能读取到上一个命令延迟的回复。
+	if v, err := c.Do(ctx, "EXISTS", "b"); v != int64(0) || err != nil {
+		t.Fatalf("got %v %v, want 0", v, err)
+	}
+
+	// 没有截止时间的ctx取消时也要返回。
+	f.mu.Lock()
+	f.delay = 100 * time.Millisecond
+	f.mu.Unlock()
+	cctx, cancel2 := context.WithCancel(ctx)
+	time.AfterFunc(10*time.Millisecond, cancel2)
+	if _, err := c.Do(cctx, "EXISTS", "a"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("got %v, want Canceled", err)
+	}
+	if v, err := c.Do(ctx, "EXISTS", "b"); v != int64(0) || err != nil {
+		t.Fatalf("got %v %v, want 0", v, err)
+	}
+	c.Close()
+	if _, err := c.Do(ctx, "EXISTS", "a"); err == nil {
+		t.Fatal("should return error after Close")
+	}
+}
+
+func TestDevices(t *testing.T) {
+	safesession.Test = true
+	defer func() { safesession.Test = false }()
+	s := newStore(t, time.Hour)
+	c := safesession.NewControlWithStore(func(s string) string { return s }, func(s string) string { return s }, time.Hour, 0, nil, s.ContextStore())
+	ctx := context.Background()
+	ua := "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36"
+	var ids []string
+	for range 3 {
+		se, err := c.NewSessionContext(ctx, "", ua, "user")
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, se.ID)
+	}
+	list, err := c.ListSessionsContext(ctx, "user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 3 || list[0].Os != "Windows" || list[0].Browser != "Chrome" || list[0].CreateTime.IsZero() {
+		t.Fatalf("%+v", list)
+	}
+	if err := c.RevokeAllExceptContext(ctx, "user", ids[1]); err != nil {
+		t.Fatal(err)
+	}
+	list, err = c.ListSessionsContext(ctx, "user")
+	if err != nil || len(list) != 1 || list[0].ID != ids[1] {
+		t.Fatalf("%+v %v", list, err)
+	}
+}
+
+func TestRotate(t *testing.T) {
+	safesession.Test = true
+	defer func() { safesession.Test = false }()
+	s := newStore(t, time.Hour)
+	c := safesession.NewControlWithStore(func(s string) string { return s }, func(s string) string { return s }, time.Hour, 0, nil, s.ContextStore())
+	c.RotationInterval = time.Millisecond
+	c.RotationGrace = 50 * time.Millisecond
+	ctx := context.Background()
+	se, err := c.NewSessionContext(ctx, "", "", "user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := se
+	oldID := se.ID
+	time.Sleep(2 * time.Millisecond)
+	if ok, err := c.Check("", "", &se); !ok || se.ID == oldID {
+		t.Fatal(err, se.ID)
+	}
+	db := s.DB()
+	// 宽限期内携带旧ID的请求不延长它的有效期，也不得到新ID。
+	for range 3 {
+		time.Sleep(10 * time.Millisecond)
+		o := old
+		if ok, err := c.Check("", "", &o); !ok || o.ID != oldID {
+			t.Fatal(err, o.ID)
+		}
+	}
+	if !db.Exist(oldID) {
+		t.Fatal("rotated ID should exist in grace period")
+	}
+	time.Sleep(30 * time.Millisecond)
+	if db.Exist(oldID) {
+		t.Fatal("rotated ID should expire after grace period")
+	}
+	if !db.Exist(se.ID) {
+		t.Fatal("new ID should exist")
+	}
+}
@@ -0,0 +1,188 @@
+package redisstore
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Error 是Redis返回的错误。
+type Error string
+
+func (e Error) Error() string { return string(e) }
+
+// Conn 是使用RESP协议的最小Redis客户端，实现了 [Client] 。
+//
+// 同一时刻只发送一个命令，从多个goroutine调用是安全的。
+// 发生网络或协议错误（包括ctx取消）后，无法知道连接上是否还有未读取的回复，
+// 所以关闭连接，下次调用时重新连接。
+// 生产环境可以用有连接池的客户端实现 [Client] 。
+type Conn struct {
+	mu      sync.Mutex
+	network string
+	addr    string
+	closed  bool
+	// conn 为nil表示需要重新连接。
+	conn net.Conn
+	r    *bufio.Reader
+	w    *bufio.Writer
+}
+
+var errClosed = errors.New("redisstore: 连接已关闭")
+
+// Dial 连接到Redis服务器。
+func Dial(ctx context.Context, network, addr string) (*Conn, error) {
+	c := &Conn{network: network, addr: addr}
+	if err := c.dial(ctx); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *Conn) dial(ctx context.Context) error {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, c.network, c.addr)
+	if err != nil {
+		return err
+	}
+	c.conn, c.r, c.w = conn, bufio.NewReader(conn), bufio.NewWriter(conn)
+	return nil
+}
+
+// Close 关闭连接。
+func (c *Conn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	if c.conn == nil {
+		return nil
+	}
+	err := c.conn.Close()
+	c.conn = nil
+	return err
+}
+
+// Do 实现 [Client] 。
+func (c *Conn) Do(ctx context.Context, args ...string) (any, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return nil, errClosed
+	}
+	if c.conn == nil {
+		if err := c.dial(ctx); err != nil {
+			return nil, err
+		}
+	}
+	v, err := c.do(ctx, args)
+	var e Error
+	if err != nil && !errors.As(err, &e) {
+		// 连接上可能还有这个命令的回复，不能再使用。
+		c.conn.Close()
+		c.conn = nil
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+	}
+	return v, err
+}
+
+func (c *Conn) do(ctx context.Context, args []string) (v any, err error) {
+	// stop返回false时回调可能仍在运行，而Do可能已经将c.conn设置为nil，
+	// 所以回调只使用这次请求的连接。
+	conn := c.conn
+	// ctx取消或超时时使阻塞的读写立即返回。
+	// 不直接使用ctx的截止时间，以免连接超时时ctx.Err()仍然为nil。
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Unix(1, 0)) })
+	defer func() {
+		if !stop() {
+			// 即使已经读取了回复，连接的截止时间也已经被修改。
+			v, err = nil, ctx.Err()
+			return
+		}
+		conn.SetDeadline(time.Time{})
+	}()
+	fmt.Fprintf(c.w, "*%d\r\n", len(args))
+	for _, a := range args {
+		fmt.Fprintf(c.w, "$%d\r\n%s\r\n", len(a), a)
+	}
+	if err := c.w.Flush(); err != nil {
+		return nil, err
+	}
+	return readReply(c.r)
+}
+
+var errProtocol = errors.New("redisstore: 无效的RESP回复")
+
+// readReply 读取一个RESP回复。
+// 简单字符串和批量字符串返回string，整数返回int64，
+// 数组返回[]any，空值返回nil，错误返回 [Error] 。
+func readReply(r *bufio.Reader) (any, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 {
+		return nil, errProtocol
+	}
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, Error(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, errProtocol
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		b := make([]byte, n+2)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return nil, err
+		}
+		return string(b[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, errProtocol
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		ret := make([]any, n)
+		for i := range ret {
+			ret[i], err = readReply(r)
+			if err != nil {
+				var e Error
+				if !errors.As(err, &e) {
+					return nil, err
+				}
+				ret[i] = e
+			}
+		}
+		return ret, nil
+	}
+	return nil, errProtocol
+}
+
+// readLine 读取以\r\n结尾的一行，不包括\r\n。
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	if len(line) < 2 || line[len(line)-2] != '\r' {
+		return "", errProtocol
+	}
+	return line[:len(line)-2], nil
+}
@@ -0,0 +1,341 @@
+package safesession
+
+import (
+	"math"
+	"time"
+
+	"github.com/mileusna/useragent"
+)
+
+// 下列常量是被盗检查中各项特征的名称。
+const (
+	// SignalOs 是系统类型。
+	SignalOs = "os"
+	// SignalBrowser 是浏览器名。
+	SignalBrowser = "browser"
+	// SignalDevice 是浏览器指纹或设备指纹。
+	SignalDevice = "device"
+	// SignalIP 是 [Control.CheckIPInfo] 检查的ip信息。
+	SignalIP = "ip"
+	// SignalISP 是ip的网络运营商。
+	SignalISP = "isp"
+	// SignalAS 是ip的AS号。
+	SignalAS = "as"
+	// SignalCountry 是ip属地的国家。
+	SignalCountry = "country"
+	// SignalRegion 是ip属地的省份。
+	SignalRegion = "region"
+	// SignalDistance 是ip定位的距离。
+	SignalDistance = "distance"
+	// SignalPNum 是逻辑处理器数量。
+	SignalPNum = "pnum"
+	// SignalOsVersion 是系统版本。
+	SignalOsVersion = "os_version"
+	// SignalScreenWidth 是屏幕宽度。
+	SignalScreenWidth = "screen_width"
+	// SignalScreenHeight 是屏幕高度。
+	SignalScreenHeight = "screen_height"
+	// SignalGps 是gps定位的距离。
+	SignalGps = "gps"
+)
+
+// Signal 是被盗检查中一项特征的检查结果。
+type Signal struct {
+	// Name 是特征名称，是Signal开头的常量之一。
+	Name string
+	// Match 报告特征在两次登录时是否一致。
+	Match bool
+	// Weight 是 [RiskPolicy] 设置的特征对风险分数的贡献，
+	// 可能是负数，表示降低风险。
+	Weight int
+}
+
+// CheckResult 是 [Control.CheckDetailed] 的检查结果。
+type CheckResult struct {
+	// Signals 是所有被检查的特征。
+	// 未设置的特征，比如PNum为-1，不会被检查。
+	Signals []Signal
+	// Score 是风险分数。
+	Score int
+	// Decision 是 [RiskPolicy] 做出的决定。
+	Decision Decision
+	// Pass 报告是否通过检查。
+	Pass bool
+	// CallBack 报告是否因为 [Control.CheckCallBack] 通过检查。
+	CallBack bool
+	// Err 是未通过检查的原因。
+	Err error
+}
+
+// Signal 返回名为name的特征的检查结果。
+// 如果没有检查这项特征，返回false。
+func (r *CheckResult) Signal(name string) (Signal, bool) {
+	for _, v := range r.Signals {
+		if v.Name == name {
+			return v, true
+		}
+	}
+	return Signal{}, false
+}
+
+// location 是因为 [Control.MaxTravelSpeed] 通过定位检查时的新定位，
+// 通过检查后记录到 [Session] ，以便下次检查从新定位计算移动速度。
+type location struct {
+	moved bool
+	ip    IPInfo
+	gps   GpsInfo
+}
+
+// apply 在定位因为移动速度合理通过检查时，记录新定位。
+func (l location) apply(s *Session) {
+	if !l.moved {
+		return
+	}
+	s.Ip, s.Gps = l.ip, l.gps
+	s.LocatedAt = s.CreateTime
+}
+
+// locatedAt 返回记录定位的时间，
+// 升级前创建的登录会话使用首次登录时间或最近一次登录时间代替。
+func (s *Session) locatedAt() time.Time {
+	if !s.LocatedAt.IsZero() {
+		return s.LocatedAt
+	}
+	if !s.IssuedAt.IsZero() {
+		return s.IssuedAt
+	}
+	return s.CreateTime
+}
+
+// signals 检查所有特征在两次登录时是否一致。
+// 如果定位因为移动速度合理通过检查，还返回新定位。
+func (c *Control) signals(clientIP, userAgent string, s *Session, p PostInfo) ([]Signal, location) {
+	ret := make([]Signal, 0, 13)
+	loc := location{ip: s.Ip, gps: s.Gps}
+	elapsed := time.Since(s.locatedAt())
+	add := func(name string, match bool) {
+		ret = append(ret, Signal{Name: name, Match: match})
+	}
+
+	// 高灵敏度特征
+	u := useragent.Parse(userAgent)
+	add(SignalOs, u.OS == s.Os)
+	add(SignalBrowser, u.Name == s.Broswer)
+
+	// 高特异性特征
+	if s.Device != "" {
+		add(SignalDevice, s.Device == p.Device)
+	}
+
+	// 如果是测试
+	// 就不要检查ip信息在创建登录会话和现在使用登录会话时是否一致。
+	if !Test {
+		userIp := c.getIPInfo(clientIP)
+		if c.CheckIPInfo != nil {
+			add(SignalIP, c.CheckIPInfo(s.Ip, userIp))
+		} else {
+			if s.Ip.ISP != "" {
+				add(SignalISP, s.Ip.ISP == userIp.ISP)
+			}
+			if s.Ip.AS != -1 {
+				add(SignalAS, s.Ip.AS == userIp.AS)
+			}
+			if s.Ip.Country != "" && !c.AllowCountryChange {
+				add(SignalCountry, s.Ip.Country == userIp.Country)
+			}
+			if s.Ip.Region != "" && !c.AllowRegionChange {
+				add(SignalRegion, s.Ip.Region == userIp.Region)
+			}
+			d := Distance(s.Ip.Latitude, s.Ip.Longitude, userIp.Latitude, userIp.Longitude)
+			match := c.travelOK(d, c.ipRadius(), elapsed)
+			add(SignalDistance, match)
+			if match && d > c.ipRadius() {
+				loc.moved = true
+			}
+			loc.ip = userIp
+		}
+	}
+
+	if s.PNum != -1 {
+		add(SignalPNum, s.PNum == p.PNum)
+	}
+	if s.OsVersion != "" {
+		add(SignalOsVersion, s.OsVersion == u.OSVersion)
+	}
+	if s.Screen.Height != -1 {
+		add(SignalScreenHeight, s.Screen.Height == p.Screen.Height)
+	}
+	if s.Screen.Width != -1 {
+		add(SignalScreenWidth, s.Screen.Width == p.Screen.Width)
+	}
+	if s.Gps.Latitude != math.MaxFloat64 && s.Gps.Longitude != math.MaxFloat64 {
+		match := false
+		if p.Gps.Latitude != math.MaxFloat64 && p.Gps.Longitude != math.MaxFloat64 {
+			d := Distance(s.Gps.Latitude, s.Gps.Longitude, p.Gps.Latitude, p.Gps.Longitude)
+			match = c.travelOK(d, c.gpsRadius(), elapsed)
+			if match && d > c.gpsRadius() {
+				loc.moved = true
+			}
+			loc.gps = p.Gps
+		}
+		add(SignalGps, match)
+	}
+	return ret, loc
+}
+
+// defaultRadius 是默认允许两次登录定位相差的距离，单位：公里。
+const defaultRadius = 50
+
+// gpsRadius 返回允许两次登录gps定位相差的距离。
+func (c *Control) gpsRadius() float64 {
+	if c.GpsRadius > 0 {
+		return c.GpsRadius
+	}
+	return defaultRadius
+}
+
+// ipRadius 返回允许两次登录ip定位相差的距离。
+func (c *Control) ipRadius() float64 {
+	if c.IPRadius > 0 {
+		return c.IPRadius
+	}
+	return defaultRadius
+}
+
+// travelOK 报告在记录定位后的elapsed时间内移动distance公里是否合理。
+//
+// 不超过radius公里总是合理的；
+// 否则如果设置了 [Control.MaxTravelSpeed] ，速度不超过它是合理的。
+func (c *Control) travelOK(distance, radius float64, elapsed time.Duration) bool {
+	if distance <= radius {
+		return true
+	}
+	if c.MaxTravelSpeed <= 0 || elapsed <= 0 {
+		return false
+	}
+	return distance/elapsed.Hours() <= c.MaxTravelSpeed
+}
+
+// Decision 是被盗检查的决定。
+type Decision int
+
+const (
+	// Allow 表示通过被盗检查。
+	Allow Decision = iota
+	// StepUp 表示需要二次验证。
+	StepUp
+	// Deny 表示不通过被盗检查。
+	Deny
+)
+
+// RiskPolicy 根据各项特征的检查结果做出决定。
+//
+// 从多个goroutine调用里面的方法应该是安全的。
+type RiskPolicy interface {
+	// Evaluate 设置r中每项特征的权重和风险分数，返回决定。
+	Evaluate(r *CheckResult) Decision
+}
+
+// WeightedPolicy 是基于权重的 [RiskPolicy] 。
+//
+// 风险分数是所有被检查的特征的权重之和。
+// 风险分数不小于Deny时，决定为 [Deny] ；
+// 否则StepUp大于0且风险分数不小于StepUp时，决定为 [StepUp] ；
+// 否则决定为 [Allow] 。
+type WeightedPolicy struct {
+	// Mismatch 是特征不一致时的权重，键是特征名称。
+	// 没有设置的特征权重为0。
+	Mismatch map[string]int
+	// Match 是特征一致时的权重，键是特征名称。
+	// 没有设置的特征权重为0。
+	Match map[string]int
+	// StepUp 是需要二次验证的风险分数。
+	StepUp int
+	// Deny 是不通过被盗检查的风险分数，
+	// 应该大于0，否则所有检查都不通过。
+	Deny int
+}
+
+// hardWeight 是高灵敏度特征不一致时的默认权重，
+// 即使设备指纹一致也不能通过检查。
+const hardWeight = 100
+
+// NewWeightedPolicy 创建默认的 [WeightedPolicy] 。
+//
+// 系统类型或浏览器名不一致时，不通过检查；
+// 设备指纹一致时，其他特征不一致也通过检查；
+// 否则任何一项特征不一致，都不通过检查。
+func NewWeightedPolicy() *WeightedPolicy {
+	return &WeightedPolicy{
+		Mismatch: map[string]int{
+			SignalOs:           hardWeight,
+			SignalBrowser:      hardWeight,
+			SignalIP:           1,
+			SignalISP:          1,
+			SignalAS:           1,
+			SignalCountry:      1,
+			SignalRegion:       1,
+			SignalDistance:     1,
+			SignalPNum:         1,
+			SignalOsVersion:    1,
+			SignalScreenWidth:  1,
+			SignalScreenHeight: 1,
+			SignalGps:          1,
+		},
+		Match: map[string]int{
+			SignalDevice: -(hardWeight - 1),
+		},
+		Deny: 1,
+	}
+}
+
+// Evaluate 实现 [RiskPolicy] 。
+func (p *WeightedPolicy) Evaluate(r *CheckResult) Decision {
+	r.Score = 0
+	for i := range r.Signals {
+		s := &r.Signals[i]
+		if s.Match {
+			s.Weight = p.Match[s.Name]
+		} else {
+			s.Weight = p.Mismatch[s.Name]
+		}
+		r.Score += s.Weight
+	}
+	if r.Score >= p.Deny {
+		return Deny
+	}
+	if p.StepUp > 0 && r.Score >= p.StepUp {
+		return StepUp
+	}
+	return Allow
+}
+
+var defaultPolicy = NewWeightedPolicy()
+
+// policy 返回使用的 [RiskPolicy] 。
+func (c *Control) policy() RiskPolicy {
+	if c.RiskPolicy != nil {
+		return c.RiskPolicy
+	}
+	return defaultPolicy
+}
+
+// riskErr 返回未通过被盗检查的原因。
+func (r *CheckResult) riskErr() error {
+	region := false
+	for _, v := range r.Signals {
+		if v.Match {
+			continue
+		}
+		switch v.Name {
+		case SignalOs, SignalBrowser:
+			return MayStolen
+		case SignalCountry, SignalRegion, SignalDistance:
+			region = true
+		}
+	}
+	if region {
+		return RegionErr
+	}
+	return MayStolen
+}
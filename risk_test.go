@@ -0,0 +1,188 @@
+package safesession
+
+import (
+	"context"
+	"math"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCheckDetailed(t *testing.T) {
+	defer func(n int) { delete_num = n }(delete_num)
+	original := c.CheckIPInfo
+	c.CheckIPInfo = nil
+	defer func() { c.CheckIPInfo = original }()
+
+	t.Run("pass", func(t *testing.T) {
+		s := c.NewSession("192.168.0.1", user_agent, "ok")
+		s.SetPostInfo(PostInfo{Device: "1", PNum: 2, Screen: Screen{Height: 1, Width: 2}})
+		r := c.CheckDetailed("192.168.0.3", user_agent, &s, PostInfo{Device: "1", PNum: 3, Screen: Screen{Height: 1, Width: 2}})
+		if !r.Pass || r.Err != nil {
+			t.Fatalf("%+v", r)
+		}
+		for name, match := range map[string]bool{SignalDevice: true, SignalAS: false, SignalPNum: false, SignalScreenHeight: true, SignalOs: true} {
+			if sig, ok := r.Signal(name); !ok || sig.Match != match {
+				t.Fatalf("%s: got %+v, want match %v", name, sig, match)
+			}
+		}
+		if _, ok := r.Signal(SignalISP); ok {
+			t.Fatal("ISP should not be evaluated")
+		}
+	})
+	t.Run("stolen", func(t *testing.T) {
+		s := c.NewSession("192.168.0.1", user_agent, "ok")
+		s.SetPostInfo(PostInfo{PNum: 2, Screen: Screen{Height: -1, Width: -1}})
+		r := c.CheckDetailed("192.168.0.1", user_agent, &s, PostInfo{PNum: 3})
+		if r.Pass || r.Err != MayStolen || r.Score != 1 {
+			t.Fatalf("%+v", r)
+		}
+		if sig, _ := r.Signal(SignalPNum); sig.Match || sig.Weight != 1 {
+			t.Fatalf("%+v", sig)
+		}
+	})
+}
+
+func TestRiskPolicy(t *testing.T) {
+	defer func(n int) { delete_num = n }(delete_num)
+	original := c.CheckIPInfo
+	c.CheckIPInfo = nil
+	defer func() { c.CheckIPInfo = original }()
+	p := NewWeightedPolicy()
+	p.Mismatch[SignalPNum] = 0
+	p.Mismatch[SignalAS] = 2
+	p.Deny = 2
+	c.RiskPolicy = p
+	defer func() { c.RiskPolicy = nil }()
+
+	s := c.NewSession("192.168.0.1", user_agent, "ok")
+	s.SetPostInfo(PostInfo{PNum: 2, Screen: Screen{Height: -1, Width: -1}})
+	if r := c.CheckDetailed("192.168.0.1", user_agent, &s, PostInfo{PNum: 3}); !r.Pass || r.Decision != Allow {
+		t.Fatalf("%+v", r)
+	}
+	if r := c.CheckDetailed("192.168.0.3", user_agent, &s, PostInfo{PNum: 2}); r.Pass || r.Decision != Deny || r.Score != 2 {
+		t.Fatalf("%+v", r)
+	}
+}
+
+func TestStepUp(t *testing.T) {
+	original := c.CheckIPInfo
+	c.CheckIPInfo = nil
+	defer func() { c.CheckIPInfo = original }()
+	p := NewWeightedPolicy()
+	p.StepUp = 1
+	p.Deny = hardWeight
+	c.RiskPolicy = p
+	defer func() { c.RiskPolicy = nil }()
+
+	s := c.NewSession("192.168.0.1", user_agent, "ok")
+	s.SetPostInfo(PostInfo{PNum: 2, Screen: Screen{Height: -1, Width: -1}})
+	if ok, err := c.Check("192.168.0.1", user_agent, &s, PostInfo{PNum: 3}); ok || err != NeedStepUp {
+		t.Fatal(ok, err)
+	}
+	if ok, _ := c.store.Exist(context.Background(), s.ID); !ok {
+		t.Fatal("should keep Session")
+	}
+	r := httptest.NewRequest("POST", "/", nil)
+	r.RemoteAddr = "192.168.0.1:1234"
+	r.Header.Set("User-Agent", user_agent)
+	w := httptest.NewRecorder()
+	if err := c.CompleteStepUp(&s, r, w, PostInfo{PNum: 3, Screen: Screen{Height: -1, Width: -1}}); err != nil {
+		t.Fatal(err)
+	}
+	if len(w.Result().Cookies()) != 1 {
+		t.Fatal("should re-issue cookie")
+	}
+	if ok, err := c.Check("192.168.0.1", user_agent, &s, PostInfo{PNum: 3}); !ok || err != nil {
+		t.Fatal(ok, err)
+	}
+}
+
+func TestGps(t *testing.T) {
+	defer func(n int) { delete_num = n }(delete_num)
+	original := c.CheckIPInfo
+	c.CheckIPInfo = nil
+	defer func() { c.CheckIPInfo = original }()
+	defer func() { c.MaxTravelSpeed = 0 }()
+
+	tests := []struct {
+		name  string
+		speed float64
+		since time.Duration
+		gps   GpsInfo
+		match bool
+	}{
+		{"near", 0, time.Minute, GpsInfo{Latitude: 30.1, Longitude: 120}, true},
+		{"far", 0, 2 * time.Hour, GpsInfo{Latitude: 31, Longitude: 120}, false},
+		{"far but possible", 1000, 2 * time.Hour, GpsInfo{Latitude: 31, Longitude: 120}, true},
+		{"impossible travel", 1000, time.Minute, GpsInfo{Latitude: 31, Longitude: 120}, false},
+		{"no gps", 0, time.Minute, GpsInfo{Latitude: math.MaxFloat64, Longitude: math.MaxFloat64}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c.MaxTravelSpeed = tt.speed
+			s := c.NewSession("192.168.0.1", user_agent, "ok")
+			s.SetPostInfo(PostInfo{PNum: -1, Screen: Screen{Height: -1, Width: -1}, Gps: GpsInfo{Latitude: 30, Longitude: 120}})
+			s.LocatedAt = time.Now().Add(-tt.since)
+			r := c.CheckDetailed("192.168.0.1", user_agent, &s, PostInfo{PNum: -1, Gps: tt.gps})
+			if sig, ok := r.Signal(SignalGps); !ok || sig.Match != tt.match || r.Pass != tt.match {
+				t.Fatalf("%+v", r)
+			}
+			// 通过后记录新定位，马上在新定位再次检查也通过。
+			if tt.match {
+				if r := c.CheckDetailed("192.168.0.1", user_agent, &s, PostInfo{PNum: -1, Gps: tt.gps}); !r.Pass {
+					t.Fatalf("%+v", r)
+				}
+			}
+		})
+	}
+}
+
+func TestImpossibleTravel(t *testing.T) {
+	defer func(n int) { delete_num = n }(delete_num)
+	original := c.CheckIPInfo
+	c.CheckIPInfo = nil
+	original2 := c.CheckCallBack
+	c.CheckCallBack = nil
+	defer func() { c.CheckIPInfo, c.CheckCallBack = original, original2 }()
+	defer func() { c.MaxTravelSpeed, c.AllowCountryChange, c.IPRadius = 0, false, 0 }()
+
+	check := func(ip string, since time.Duration) CheckResult {
+		s := c.NewSession("192.168.0.1", user_agent, "ok")
+		s.LocatedAt = time.Now().Add(-since)
+		return c.CheckDetailed(ip, user_agent, &s)
+	}
+	if r := check("192.168.0.4", time.Hour); r.Err != RegionErr {
+		t.Fatalf("%+v", r)
+	}
+	c.IPRadius = 100
+	if r := check("192.168.0.4", time.Hour); !r.Pass {
+		t.Fatalf("%+v", r)
+	}
+	c.IPRadius = 0
+	c.MaxTravelSpeed = 1000
+	if r := check("192.168.0.4", time.Hour); !r.Pass {
+		t.Fatalf("%+v", r)
+	}
+	if r := check("192.168.0.4", time.Second); r.Err != RegionErr {
+		t.Fatalf("%+v", r)
+	}
+	// 通过后记录新定位，之后的检查从新定位计算移动速度。
+	s := c.NewSession("192.168.0.1", user_agent, "ok")
+	s.LocatedAt = time.Now().Add(-time.Hour)
+	for i := range 2 {
+		if r := c.CheckDetailed("192.168.0.4", user_agent, &s); !r.Pass {
+			t.Fatalf("check %d: %+v", i, r)
+		}
+	}
+	if r := c.CheckDetailed("192.168.0.1", user_agent, &s); r.Err != RegionErr {
+		t.Fatalf("%+v", r)
+	}
+	if r := check("192.168.0.2", time.Hour); r.Err != RegionErr {
+		t.Fatalf("%+v", r)
+	}
+	c.AllowCountryChange = true
+	if r := check("192.168.0.2", time.Hour); !r.Pass {
+		t.Fatalf("%+v", r)
+	}
+}
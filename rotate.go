@@ -0,0 +1,144 @@
+package safesession
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// defaultRotationGrace 是 [Control.RotationGrace] 的默认值。
+const defaultRotationGrace = 30 * time.Second
+
+// RotateStore 是可以原子地更换登录会话ID的 [Store] ，
+// 用于 [Control.Rotate] 和 [Control.RotationInterval] 。
+type RotateStore interface {
+	Store
+	// Rotate 原子地存储newID并使oldID失效，
+	// newID继承oldID的用户，设备摘要和首次登录时间，最近一次登录时间为CreateTime。
+	// grace大于0时oldID在grace后失效，期间Update不改变oldID。
+	// 返回当前应该使用的ID：
+	// 如果oldID已经在宽限期内被更换，返回之前更换的ID，以便并发的请求得到相同的ID；
+	// 如果newID重复，返回空字符串；
+	// 如果oldID不存在，返回 [NotLogined] 。
+	Rotate(ctx context.Context, oldID, newID string, CreateTime time.Time, grace time.Duration) (string, error)
+}
+
+// rotatedPrefix 是数据库不是 [RotateStore] 时，记录旧ID已经被更换的标记的ID前缀。
+// ID是标准base64编码，不包含':'，所以标记不会与ID重复。
+const rotatedPrefix = "rotated:"
+
+func (c *Control) rotationGrace() time.Duration {
+	if c.RotationGrace <= 0 {
+		return defaultRotationGrace
+	}
+	return c.RotationGrace
+}
+
+// Rotate 更换登录会话ID并重新响应cookie，
+// 应该在登录，提升权限等权限变化时调用，以免泄露的旧ID继续有效。
+// 数据库是 [RotateStore] 时原子地更换，否则先存储新ID再删除旧ID。
+// 新ID继承首次登录时间和用户，旧ID立即失效。
+// 如果登录会话已经不存在，返回 [NotLogined] 。
+// 只要每次调用的w不同，从多个goroutine调用是安全的。
+func (c *Control) Rotate(s *Session, w http.ResponseWriter) error {
+	return c.RotateContext(context.Background(), s, w)
+}
+
+// RotateContext 与 [Control.Rotate] 相同，但是接受context。
+func (c *Control) RotateContext(ctx context.Context, s *Session, w http.ResponseWriter) error {
+	if err := c.rotate(ctx, s, 0); err != nil {
+		return err
+	}
+	return c.SetSession(s, w)
+}
+
+// rotate 更换s的ID，旧ID在grace后失效。
+func (c *Control) rotate(ctx context.Context, s *Session, grace time.Duration) error {
+	now := time.Now()
+	rs, ok := c.store.(RotateStore)
+	if !ok {
+		return c.rotateFallback(ctx, s, now, grace)
+	}
+	for {
+		id, err := rs.Rotate(ctx, s.ID, genID(), now, grace)
+		if err == NotLogined {
+			return NotLogined
+		}
+		if err != nil {
+			return storageErr(err)
+		}
+		// 在ID不重复时返回。
+		if id != "" {
+			s.ID, s.CreateTime, s.RotatedAt = id, now, now
+			return nil
+		}
+	}
+}
+
+// rotateFallback 在数据库不是 [RotateStore] 时先存储新ID，再使旧ID在grace后失效。
+// 同时存储在grace后过期的标记，参见 [Control.rotatedFallback] 。
+// 不是原子的，在标记存储前携带旧ID的并发请求会得到不同的新ID。
+func (c *Control) rotateFallback(ctx context.Context, s *Session, now time.Time, grace time.Duration) error {
+	exist, err := c.store.Exist(ctx, s.ID)
+	if err != nil {
+		return storageErr(err)
+	}
+	if !exist {
+		return NotLogined
+	}
+	var id string
+	for {
+		id = genID()
+		ok, err := c.storeRotated(ctx, id, s.Name, now)
+		if err != nil {
+			return storageErr(err)
+		}
+		// 在ID不重复时继续。
+		if ok {
+			break
+		}
+	}
+	// 新ID继承首次登录时间，以免每次更换都重新计算 [Control.AbsoluteLifetime] 。
+	if is, ok := c.store.(IssuedAtStore); ok && !s.IssuedAt.IsZero() {
+		if err := is.SetIssuedAt(ctx, id, s.IssuedAt); err != nil {
+			return storageErr(err)
+		}
+	}
+	if grace > 0 {
+		// 使旧ID和标记在grace后过期。
+		expire := now.Add(grace - c.sessionMaxAge)
+		if _, err := c.store.Store(ctx, rotatedPrefix+s.ID, expire); err != nil {
+			return storageErr(err)
+		}
+		err = c.store.Update(ctx, s.ID, expire)
+	} else {
+		err = c.store.Delete(ctx, s.ID)
+	}
+	if err != nil {
+		return storageErr(err)
+	}
+	s.ID, s.CreateTime, s.RotatedAt = id, now, now
+	return c.SaveDeviceContext(ctx, s)
+}
+
+// rotatedFallback 报告数据库不是 [RotateStore] 时，ID是否已经被自动更换。
+// 此时ID在宽限期内仍然有效，但是不应该延长有效期或再次更换，
+// 否则携带泄露的旧ID的请求可以一直使用它。
+func (c *Control) rotatedFallback(ctx context.Context, ID string) (bool, error) {
+	if _, ok := c.store.(RotateStore); ok || c.RotationInterval <= 0 {
+		return false, nil
+	}
+	ok, err := c.store.Exist(ctx, rotatedPrefix+ID)
+	return ok, storageErr(err)
+}
+
+// storeRotated 存储更换后的新ID，如果设置了 [Control.MaxSessionsPerUser] ，检查数量限制。
+func (c *Control) storeRotated(ctx context.Context, id, UserName string, now time.Time) (bool, error) {
+	ls, ok := c.store.(LimitStore)
+	if c.MaxSessionsPerUser <= 0 || !ok {
+		return c.store.Store(ctx, id, now)
+	}
+	// 旧ID在宽限期内仍然计数，所以上限加1，
+	// 并且更换ID不应该被拒绝。
+	return ls.StoreLimited(ctx, id, UserName, now, c.MaxSessionsPerUser+1, EvictOldest)
+}
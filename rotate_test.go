@@ -0,0 +1,32 @@
+package safesession
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRotate(t *testing.T) {
+	defer func(n int) { delete_num = n }(delete_num)
+	ctx := context.Background()
+	s := c.NewSession("192.168.0.3", user_agent, "ok")
+	old := s.ID
+	w := httptest.NewRecorder()
+	if err := c.Rotate(&s, w); err != nil {
+		t.Fatal(err)
+	}
+	if s.ID == old {
+		t.Fatal("ID should change")
+	}
+	if ok, _ := c.store.Exist(ctx, old); ok {
+		t.Fatal("old ID should not exist")
+	}
+	ok, err, se := c.CheckLogined("192.168.0.3", user_agent, w.Result().Cookies()[0])
+	if !ok || se.ID != s.ID || !se.IssuedAt.Equal(s.IssuedAt) {
+		t.Fatal(ok, err, se.ID)
+	}
+	s.ID = old
+	if err := c.Rotate(&s, httptest.NewRecorder()); err != NotLogined {
+		t.Fatal(err)
+	}
+}
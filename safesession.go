@@ -4,21 +4,23 @@
 //
 // 在Session的基础上，通过各种辅助验证，以及对加密的巧妙运用，做到了在安全维持登录会话的同时，自身仅存储少量数据，并且即使泄露登录会话凭据，也不太影响安全性。
 //
-// AES-256-GCM的加解密函数可以从https://github.com/qiulaidongfeng/key获取
+// 可以使用 [NewAESGCM] 创建AES-256-GCM的 [Sealer] ，
+// 也可以使用 [FuncSealer] 包装https://github.com/qiulaidongfeng/key获取的加解密函数。
 package safesession
 
 import (
+	"context"
 	"crypto/rand"
 	"encoding/base32"
 	"encoding/base64"
 	"errors"
 	"math"
 	"net/http"
+	"net/netip"
+	"strings"
 	"time"
-	"unsafe"
 
 	"github.com/mileusna/useragent"
-	"github.com/qiulaidongfeng/safesession/v3/codec"
 )
 
 // Test 为true将在创建 [Session] 时不获取ip属地。
@@ -28,27 +30,89 @@ var Test = false
 //
 // 零值无效，必须使用 [NewControl] 初始化。
 type Control struct {
-	// db 是用来保存 [Session] 的数据库。
-	db DB
+	// store 是用来保存 [Session] 的数据库。
+	store Store
 	// sessionMaxAge 设置 [Session] 本身的有效期。
 	sessionMaxAge time.Duration
 	// sameSite 设置 [Session] 保存到cookie的sameSite属性，
 	// 默认为Lex，确保从浏览器搜索结果进入网站时，能够自动登录。
 	sameSite http.SameSite
-	// encrypt,decrypt 加解密 [Session]
-	encrypt, decrypt func(string) string
+	// sealer 加解密没有密钥ID的 [Session] 。
+	sealer Sealer
+	// keyring 如果不为nil，用于加解密 [Session] 。
+	keyring *Keyring
 	// getIPInfo 获取IP信息。
 	getIPInfo func(clientIp string) IPInfo
+	// trustedProxies 是受信任的代理。
+	trustedProxies []netip.Prefix
 	// CheckIPInfo 允许调用者覆盖默认检查IP信息是否相差过大逻辑。
 	CheckIPInfo func(old, new IPInfo) bool
 	// CookieName 允许调用者设置写入响应的Cookie name
+	// 在从请求读取cookie时，s是零值 [Session] 。
 	CookieName func(s *Session) string
 	// CheckCallBack 在被盗检查不通过时允许调用者进行二次验证
 	CheckCallBack func(s *Session, clientIP, userAgent string, p PostInfo) bool
+	// IPRadius 是允许两次登录ip定位相差的距离，单位：公里，
+	// 默认为50。
+	IPRadius float64
+	// GpsRadius 是允许两次登录gps定位相差的距离，单位：公里，
+	// 默认为50。
+	GpsRadius float64
+	// MaxTravelSpeed 是两次登录之间合理的最大移动速度，单位：公里/小时，
+	// 同时用于ip定位和gps定位。
+	// 默认为0，表示不论速度，定位相差过大就不通过。
+	// 设置后，定位相差过大但移动速度合理时也通过，比如1000适合考虑乘坐飞机。
+	MaxTravelSpeed float64
+	// AllowCountryChange 为true时，不检查ip属地的国家在两次登录时是否一致，
+	// 通常与MaxTravelSpeed一起使用。
+	AllowCountryChange bool
+	// AllowRegionChange 为true时，不检查ip属地的省份在两次登录时是否一致，
+	// 通常与MaxTravelSpeed一起使用。
+	AllowRegionChange bool
+	// RiskPolicy 允许调用者覆盖默认的被盗检查决定逻辑，
+	// 默认使用 [NewWeightedPolicy] 。
+	RiskPolicy RiskPolicy
 	//CookieDomain 覆盖默认响应cookie的domain
 	CookieDomain func() string
 	//CookiePath 覆盖默认响应cookie的path
 	CookiePath func() string
+	// Unauthorized 允许调用者覆盖 [Control.Middleware] 在未登录时的默认响应。
+	// err 是未登录的原因。
+	Unauthorized func(w http.ResponseWriter, r *http.Request, err error)
+	// MaxClaimsSize 是 [Session.Claims] 所有键和值的总长度上限，单位：字节。
+	// 默认为1024。
+	MaxClaimsSize int
+	// MaxCookieSize 是保存 [Session] 的所有cookie的值的总长度上限，单位：字节。
+	// 默认为3800，此时不分块保存。
+	// 设置更大的值时，过长的cookie会分块保存到name.0，name.1……，
+	// 需要使用 [Control.Middleware] 或 [Control.CheckLoginedFromRequest] 读取。
+	MaxCookieSize int
+	// MaxSessionsPerUser 限制每个用户的登录会话数量，
+	// 超过时按SessionLimit处理。
+	// 默认为0，表示不限制。
+	// 数据库必须是 [LimitStore] ，否则创建登录会话时返回 [ErrNotSupported] 。
+	MaxSessionsPerUser int
+	// SessionLimit 是超过MaxSessionsPerUser时的处理方式，默认为 [EvictOldest] 。
+	SessionLimit SessionLimit
+	// AbsoluteLifetime 限制登录会话从首次登录开始的有效期，
+	// 不论用户是否一直活跃，超过后都需要重新登录，返回 [ErrAbsoluteExpired] 。
+	// 默认为0，表示不限制，此时只有sessionMaxAge限制的空闲有效期。
+	AbsoluteLifetime time.Duration
+	// RotationInterval 设置自动更换登录会话ID的间隔。
+	// 通过 [Control.Check] 时，如果距离上次更换超过它，更换ID，
+	// 调用者应该随后调用 [Control.SetSession] 重新响应cookie，
+	// [Control.Middleware] 会自动处理。
+	// 默认为0，表示不自动更换。
+	RotationInterval time.Duration
+	// RotationGrace 是自动更换ID后旧ID仍然有效的宽限期，
+	// 以免携带旧cookie的并发请求失败，默认为30秒。
+	// 数据库不是 [RotateStore] 时，更换不是原子的，
+	// 宽限期内携带旧cookie的请求继续使用旧ID，不会得到新ID。
+	RotationGrace time.Duration
+	// Base64Cookie 设置为true时，新的cookie使用base64.RawURLEncoding编码，
+	// 比默认的base32编码短约17%。
+	// 不论是否设置，都能读取两种编码的cookie。
+	Base64Cookie bool
 }
 
 // DB 包含需要的数据库操作。
@@ -69,8 +133,6 @@ type DB struct {
 }
 
 // Session 表示一个登录会话。
-//
-// 字符串字段不得包含"\x00"
 type Session struct {
 	// ID 对每个登录会话是唯一的。
 	ID string `gorm:"primaryKey;type:char(64)"`
@@ -83,7 +145,7 @@ type Session struct {
 	// Gps 是上一次登录的gps信息。
 	Gps GpsInfo `json:"-" gorm:"-:all"`
 	// CSRF_TOKEN 用来防范跨站请求伪造攻击。
-	// 调用者设置它。
+	// 可以使用 [Control.IssueCSRF] 设置，使用 [Control.VerifyCSRF] 验证。
 	CSRF_TOKEN string `json:"-" gorm:"-:all"`
 	// 下列字段是创建登录会话时的客户端设备信息，
 	// 和ip信息以及CSRF_TOKEN一起保存在客户浏览器，不在服务器保存。
@@ -101,6 +163,18 @@ type Session struct {
 	// PNum 是逻辑处理器数量，
 	// 通常使用navigator.hardwareConcurrency获取。
 	PNum int64 `json:"-" gorm:"-:all"`
+	// claims 是调用者附加的数据的二进制编码，参见 [Session.Claims] 。
+	// 保存编码后的字符串而不是map，使Session仍然可以用==比较。
+	claims string
+	// IssuedAt 是首次登录的时间，用于 [Control.AbsoluteLifetime] 。
+	// 数据库是 [IssuedAtStore] 时也保存在服务器。
+	// 升级前创建的登录会话为零值。
+	IssuedAt time.Time `json:"-" gorm:"-:all"`
+	// RotatedAt 是ID的生成时间，用于 [Control.RotationInterval] 。
+	RotatedAt time.Time `json:"-" gorm:"-:all"`
+	// LocatedAt 是记录Ip和Gps的时间，用于 [Control.MaxTravelSpeed] 。
+	// 升级前创建的登录会话为零值。
+	LocatedAt time.Time `json:"-" gorm:"-:all"`
 }
 
 // IPInfo 是ip信息。
@@ -126,8 +200,15 @@ type Screen struct {
 func NewControl(encrypt, decrypt func(string) string, sessionMaxAge time.Duration, sameSite http.SameSite,
 	getIPInfo func(clientIp string) IPInfo,
 	Db DB) *Control {
+	return NewControlWithStore(encrypt, decrypt, sessionMaxAge, sameSite, getIPInfo, FromDB(Db))
+}
+
+// NewControlWithStore 与 [NewControl] 相同，但是使用 [Store] 作为数据库。
+func NewControlWithStore(encrypt, decrypt func(string) string, sessionMaxAge time.Duration, sameSite http.SameSite,
+	getIPInfo func(clientIp string) IPInfo,
+	store Store) *Control {
 	var c = new(Control)
-	c.encrypt, c.decrypt = encrypt, decrypt
+	c.sealer = FuncSealer(encrypt, decrypt)
 	if sameSite != 0 {
 		c.sameSite = sameSite
 	} else {
@@ -135,18 +216,43 @@ func NewControl(encrypt, decrypt func(string) string, sessionMaxAge time.Duratio
 	}
 	c.sessionMaxAge = sessionMaxAge
 	c.getIPInfo = getIPInfo
-	c.db = Db
+	c.store = store
 	return c
 }
 
 // NewSession 创建一个 [Session] ，保证ID不重复。
 // 从多个goroutine调用是安全的。
+// 如果数据库返回错误，会panic，
+// 使用可能返回错误的 [Store] 时应该使用 [Control.NewSessionContext] 。
+// 数量限制与NewSessionContext相同，所以设置了 [Control.MaxSessionsPerUser] 时，
+// 超过数量限制（[Control.SessionLimit] 为 [RejectNew] ）或数据库不是 [LimitStore] 也会panic，
+// 此时应该使用NewSessionContext。
 func (c *Control) NewSession(clientIP, userAgent, UserName string) Session {
+	s, err := c.NewSessionContext(context.Background(), clientIP, userAgent, UserName)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+// NewSessionContext 与 [Control.NewSession] 相同，
+// 但是接受context，并在数据库返回错误时返回包装了 [ErrStorageUnavailable] 的错误。
+// 如果数据库是 [DeviceStore] ，同时保存设备摘要。
+// 如果设置了 [Control.MaxSessionsPerUser] 并且 [Control.SessionLimit] 为 [RejectNew] ，
+// 超过数量限制时返回 [ErrTooManySessions] 。
+func (c *Control) NewSessionContext(ctx context.Context, clientIP, userAgent, UserName string) (Session, error) {
 	s := c.newSession(clientIP, userAgent, UserName)
 	for {
+		ok, err := c.storeNew(ctx, &s)
+		if err != nil {
+			return Session{}, err
+		}
 		// 在ID不重复时返回。
-		if c.db.Store(s.ID, s.CreateTime) {
-			return s
+		if ok {
+			if err := c.SaveDeviceContext(ctx, &s); err != nil {
+				return Session{}, err
+			}
+			return s, nil
 		}
 		s.ID = genID()
 	}
@@ -168,6 +274,9 @@ func (c *Control) newSession(clientIP, userAgent, UserName string) Session {
 	s := Session{}
 	s.ID = genID()
 	s.CreateTime = time.Now()
+	s.IssuedAt = s.CreateTime
+	s.RotatedAt = s.CreateTime
+	s.LocatedAt = s.CreateTime
 	s.Name = UserName
 	if !Test { // 不要在测试时获取ip属地。
 		s.Ip = c.getIPInfo(clientIP)
@@ -184,14 +293,20 @@ func (c *Control) newSession(clientIP, userAgent, UserName string) Session {
 	return s
 }
 
-// decode 将cookie值解码为 [Session] 。
-func (s *Session) decode(v string) bool {
-	return codec.Decode(s, v)
+// decode 将解密后的cookie值解码为 [Session] 。
+// 兼容升级前使用 [codec] 编码的旧格式，
+// 下次调用 [Control.SetSession] 时会使用二进制格式重新编码。
+func (s *Session) decode(b []byte) bool {
+	if isLegacy(b) {
+		return s.decodeLegacy(b)
+	}
+	return s.UnmarshalBinary(b) == nil
 }
 
-// encode 将 [Session] 编码为字符串。
-func (s *Session) encode() string {
-	return codec.Encode(s)
+// encode 将 [Session] 编码为二进制数据。
+func (s *Session) encode() []byte {
+	b, _ := s.MarshalBinary()
+	return b
 }
 
 type PostInfo struct {
@@ -213,16 +328,46 @@ func (s *Session) SetPostInfo(i PostInfo) {
 var LoginExpired = errors.New("登录已过期，请重新登录")
 var RegionErr = errors.New("IP属地在两次登录时不在同一个地区，请重新登录")
 var MayStolen = errors.New("登录疑似存在风险，请重新登录")
+var NeedStepUp = errors.New("登录疑似存在风险，请进行二次验证")
 
 // Check 检查用户的 [Session] 是否未被盗且未登录失效。
 // 从多个goroutine调用是安全的。
 // 假设已验证Session ID未过期。
+// 如果 [RiskPolicy] 决定需要二次验证，返回 [NeedStepUp] 并保留登录会话，
+// 调用者应该在二次验证通过后调用 [Control.CompleteStepUp] 。
+// 如果需要知道每项特征的检查结果，使用 [Control.CheckDetailed] 。
+// 设置了 [Control.RotationInterval] 时，通过检查后s.ID可能改变。
 func (c *Control) Check(clientIP, userAgent string, s *Session, ps ...PostInfo) (pass bool, err error) {
+	return c.CheckContext(context.Background(), clientIP, userAgent, s, ps...)
+}
+
+// CheckContext 与 [Control.Check] 相同，但是接受context。
+// 如果数据库返回错误，返回包装了 [ErrStorageUnavailable] 的错误，
+// 此时不代表登录会话失效，调用者不应该删除cookie。
+// 返回 [NeedStepUp] 时登录会话也被保留，调用者不应该删除cookie。
+func (c *Control) CheckContext(ctx context.Context, clientIP, userAgent string, s *Session, ps ...PostInfo) (pass bool, err error) {
+	r := c.CheckDetailedContext(ctx, clientIP, userAgent, s, ps...)
+	return r.Pass, r.Err
+}
+
+// CheckDetailed 与 [Control.Check] 相同，但是返回每项特征的检查结果。
+// 从多个goroutine调用是安全的。
+func (c *Control) CheckDetailed(clientIP, userAgent string, s *Session, ps ...PostInfo) (r CheckResult) {
+	return c.CheckDetailedContext(context.Background(), clientIP, userAgent, s, ps...)
+}
+
+// CheckDetailedContext 与 [Control.CheckDetailed] 相同，
+// 但是接受context，数据库错误的处理与 [Control.CheckContext] 相同。
+func (c *Control) CheckDetailedContext(ctx context.Context, clientIP, userAgent string, s *Session, ps ...PostInfo) (r CheckResult) {
 	// 有些浏览器会发送刚过期的cookie,
 	// 所以检查登录会话本身是否已经过期。
 	if time.Since(s.CreateTime) >= c.sessionMaxAge {
-		c.db.Delete(s.ID)
-		return false, LoginExpired
+		r.Err = c.invalidate(ctx, s, LoginExpired)
+		return r
+	}
+	if err := c.checkLifetime(ctx, s); err != nil {
+		r.Err = err
+		return r
 	}
 	var p PostInfo
 	if len(ps) != 0 {
@@ -234,163 +379,233 @@ func (c *Control) Check(clientIP, userAgent string, s *Session, ps ...PostInfo)
 		p.Screen.Height = -1
 		p.Screen.Width = -1
 	}
-	// 高灵敏度特征检查
-	u := useragent.Parse(userAgent)
-	if u.OS != s.Os || u.Name != s.Broswer {
+	var loc location
+	r.Signals, loc = c.signals(clientIP, userAgent, s, p)
+	r.Decision = c.policy().Evaluate(&r)
+
+	if r.Decision != Allow {
 		if c.CheckCallBack != nil && c.CheckCallBack(s, clientIP, userAgent, p) {
-			return true, nil
+			r.Pass, r.CallBack = true, true
+			return r
+		}
+		if r.Decision == Deny {
+			r.Err = c.invalidate(ctx, s, r.riskErr())
+			return r
 		}
-		c.db.Delete(s.ID)
-		return false, MayStolen
-	}
-
-	// 高特异性特征检查
-	device_ok := false
-	if s.Device != "" && s.Device == p.Device {
-		device_ok = true
 	}
-	fail := 0
 
-	// 如果是测试
-	// 就不要检查ip信息在创建登录会话和现在使用登录会话时是否一致。
-	if !Test {
-		userIp := c.getIPInfo(clientIP)
-		if c.CheckIPInfo != nil {
-			if !c.CheckIPInfo(s.Ip, userIp) {
-				fail++
-			}
-		} else {
-			if s.Ip.ISP != "" && s.Ip.ISP != userIp.ISP {
-				fail++
-			}
-			if s.Ip.AS != -1 && s.Ip.AS != userIp.AS {
-				fail++
-			}
-			if !s.checkIp(userIp, &err) {
-				fail++
-			}
+	// 检查登录会话表示的用户登录状态。
+	// Note: 可能因为只允许在一台设备登录等原因，
+	// 即使有多个登录会话本身有效，但只有最近一个创建的登录会话能成功登录，
+	// 所以还要检查这个登录会话能否成功登录。
+	if err := c.store.Valid(ctx, s.Name, s.ID); err != nil {
+		if errors.Is(err, ErrStorageUnavailable) {
+			r.Err = err
+			return r
 		}
+		r.Err = c.invalidate(ctx, s, err)
+		return r
 	}
-
-	if s.PNum != -1 && s.PNum != p.PNum {
-		fail++
+	// 需要二次验证时保留登录会话，
+	// 但是不更新最近一次登录时间。
+	if r.Decision == StepUp {
+		r.Err = NeedStepUp
+		return r
 	}
-	if s.OsVersion != "" && s.OsVersion != u.OSVersion {
-		fail++
+	rotated, err := c.rotatedFallback(ctx, s.ID)
+	if err != nil {
+		r.Err = err
+		return r
 	}
-	if s.Screen.Height != -1 && s.Screen.Height != p.Screen.Height {
-		fail++
+	if rotated {
+		// 旧ID在宽限期后失效，不更新最近一次登录时间。
+		r.Pass = true
+		return r
 	}
-	if s.Screen.Width != -1 && s.Screen.Width != p.Screen.Width {
-		fail++
+	s.CreateTime = time.Now()
+	if err := c.store.Update(ctx, s.ID, s.CreateTime); err != nil {
+		r.Err = storageErr(err)
+		return r
 	}
-
-	if !device_ok && fail >= 1 {
-		if c.CheckCallBack != nil && c.CheckCallBack(s, clientIP, userAgent, p) {
-			return true, nil
-		}
-		c.db.Delete(s.ID)
-		if err == nil {
-			err = MayStolen
+	loc.apply(s)
+	if c.RotationInterval > 0 && time.Since(s.RotatedAt) >= c.RotationInterval {
+		if err := c.rotate(ctx, s, c.rotationGrace()); err != nil {
+			r.Err = err
+			return r
 		}
-		return false, err
 	}
+	r.Pass = true
+	return r
+}
 
-	// 检查登录会话表示的用户登录状态。
-	// Note: 可能因为只允许在一台设备登录等原因，
-	// 即使有多个登录会话本身有效，但只有最近一个创建的登录会话能成功登录，
-	// 所以还要检查这个登录会话能否成功登录。
-	if err := c.db.Valid(s.Name, s.ID); err != nil {
-		c.db.Delete(s.ID)
-		return false, err
+// invalidate 从数据库删除登录会话，返回登录会话失效的原因reason。
+// 如果删除失败，返回包装了 [ErrStorageUnavailable] 的错误，
+// 以便下次检查时再删除。
+func (c *Control) invalidate(ctx context.Context, s *Session, reason error) error {
+	if err := c.store.Delete(ctx, s.ID); err != nil {
+		return storageErr(err)
 	}
-	s.CreateTime = time.Now()
-	c.db.Update(s.ID, s.CreateTime)
-	return true, nil
+	return reason
 }
 
-func (s *Session) checkIp(newInfo IPInfo, e *error) bool {
-	if s.Ip.Country != "" && s.Ip.Country != newInfo.Country {
-		*e = RegionErr
-		return false
+// CompleteStepUp 在二次验证（比如短信验证码）通过后，
+// 使用请求中的客户端信息更新 [Session] 保存的被盗验证信息和最近一次登录时间，
+// 并重新响应cookie。
+// 如果登录会话已经不存在，返回 [NotLogined] 。
+// 只要每次调用的w不同，从多个goroutine调用是安全的。
+func (c *Control) CompleteStepUp(s *Session, r *http.Request, w http.ResponseWriter, ps ...PostInfo) error {
+	ok, err := c.store.Exist(r.Context(), s.ID)
+	if err != nil {
+		return storageErr(err)
+	}
+	if !ok {
+		return NotLogined
+	}
+	n := c.newSession(c.ClientIP(r), r.UserAgent(), s.Name)
+	s.Ip = n.Ip
+	s.Os, s.OsVersion, s.Broswer = n.Os, n.OsVersion, n.Broswer
+	if len(ps) != 0 {
+		s.SetPostInfo(ps[0])
 	}
-	if s.Ip.Region != "" && s.Ip.Region != newInfo.Region {
-		*e = RegionErr
-		return false
+	s.CreateTime = n.CreateTime
+	s.LocatedAt = n.CreateTime
+	if err := c.store.Update(r.Context(), s.ID, s.CreateTime); err != nil {
+		return storageErr(err)
 	}
-	if Distance(s.Ip.Latitude, s.Ip.Longitude, newInfo.Latitude, newInfo.Longitude) > 50 {
-		*e = RegionErr
-		return false
+	// 设备信息可能已经改变。
+	if err := c.SaveDeviceContext(r.Context(), s); err != nil {
+		return err
 	}
-	return true
+	return c.SetSession(s, w)
 }
 
 // CheckLogined 检查是否已经登录。
 // 从多个goroutine调用是安全的。
-// 如果err!=nil,调用者应该删除cookie（响应MaxAge<0）。
+// cookie分块保存时，应该使用 [Control.CheckLoginedFromRequest] 。
+// 如果登录会话因为超过 [Control.MaxSessionsPerUser] 被删除，返回 [ErrEvicted] 。
+// 如果err!=nil且不是 [ErrStorageUnavailable] 或 [NeedStepUp] ，调用者应该删除cookie（响应MaxAge<0），可以使用 [Control.ClearSession] 。
+// 返回 [NeedStepUp] 时应该保留cookie，在二次验证通过后调用 [Control.CompleteStepUp] 。
+// [Control.Middleware] 会自动完成这些步骤。
 func (c *Control) CheckLogined(clientIP, userAgent string, cookie *http.Cookie, p ...PostInfo) (bool, error, Session) {
-	ok, se := c.decodeSession(cookie.Value)
-	if ok && c.db.Exist(se.ID) {
-		ok, err := c.Check(clientIP, userAgent, &se, p...)
-		return ok, err, se
+	return c.CheckLoginedContext(context.Background(), clientIP, userAgent, cookie, p...)
+}
+
+// CheckLoginedContext 与 [Control.CheckLogined] 相同，
+// 但是接受context，数据库错误的处理与 [Control.CheckContext] 相同。
+func (c *Control) CheckLoginedContext(ctx context.Context, clientIP, userAgent string, cookie *http.Cookie, p ...PostInfo) (bool, error, Session) {
+	ok, se := c.decodeSession(cookie.Name, cookie.Value)
+	if !ok {
+		return false, nil, Session{}
+	}
+	exist, err := c.store.Exist(ctx, se.ID)
+	if err != nil {
+		return false, storageErr(err), Session{}
 	}
-	return false, nil, Session{}
+	if !exist {
+		return false, c.notExistErr(ctx, se.ID), Session{}
+	}
+	ok, err = c.CheckContext(ctx, clientIP, userAgent, &se, p...)
+	return ok, err, se
 }
 
 // SetSession 设置已创建的登录会话。
 // 只能在https时使用。
 // 只要每次调用的w不同，从多个goroutine调用是安全的。
-func (c *Control) SetSession(se *Session, w http.ResponseWriter) {
-	name := "session"
+// 如果 [Session.Claims] 过大，返回 [ErrClaimsTooLarge] ，不响应cookie。
+// 如果cookie超过 [Control.MaxCookieSize] ，返回 [ErrCookieTooLarge] ，不响应cookie。
+func (c *Control) SetSession(se *Session, w http.ResponseWriter) error {
+	if err := c.checkClaims(se); err != nil {
+		return err
+	}
+	name := c.cookieName(se)
+	v, err := c.encodeSession(name, se)
+	if err != nil {
+		return err
+	}
+	return c.setCookies(w, name, v)
+}
+
+// ClearSession 删除客户端保存登录会话的cookie。
+// 只要每次调用的w不同，从多个goroutine调用是安全的。
+func (c *Control) ClearSession(w http.ResponseWriter) {
+	name := c.cookieName(&Session{})
+	http.SetCookie(w, c.newCookie(name, "", -1))
+	c.clearChunks(w, name, 0)
+}
+
+// cookieName 返回保存 [Session] 的cookie name。
+func (c *Control) cookieName(se *Session) string {
 	if c.CookieName != nil {
-		name = c.CookieName(se)
+		return c.CookieName(se)
 	}
-	domain := ""
+	return "session"
+}
+
+// cookieDomain 返回保存 [Session] 的cookie domain。
+func (c *Control) cookieDomain() string {
 	if c.CookieDomain != nil {
-		domain = c.CookieDomain()
+		return c.CookieDomain()
 	}
-	path := "/"
+	return ""
+}
+
+// cookiePath 返回保存 [Session] 的cookie path。
+func (c *Control) cookiePath() string {
 	if c.CookiePath != nil {
-		path = c.CookiePath()
-	}
-	http.SetCookie(w, &http.Cookie{
-		Name:     name,
-		Value:    c.encodeSession(se),
-		Path:     path,
-		Domain:   domain,
-		SameSite: c.sameSite,
-		Secure:   true,
-		HttpOnly: true,
-		MaxAge:   int(c.sessionMaxAge.Seconds()),
-	})
-}
-
-// encodeSession 编码 [Session] 为cookie值。
-func (c *Control) encodeSession(se *Session) string {
-	// 编码为字符串。
-	v := se.encode()
+		return c.CookiePath()
+	}
+	return "/"
+}
+
+// encodeSession 编码 [Session] 为名为name的cookie的值。
+func (c *Control) encodeSession(name string, se *Session) (string, error) {
+	// 编码为二进制数据。
+	b := se.encode()
 	// 加密。
-	v = c.encrypt(v)
+	id, b, err := c.seal(b, c.additionalData(name))
+	if err != nil {
+		return "", err
+	}
 	// 转义为能安全地放置在URL查询的文本。
-	return base32.StdEncoding.EncodeToString(unsafe.Slice(unsafe.StringData(v), len(v)))
+	var v string
+	if c.Base64Cookie {
+		v = base64.RawURLEncoding.EncodeToString(b)
+	} else {
+		v = base32.StdEncoding.EncodeToString(b)
+	}
+	if id != "" {
+		// 加上密钥ID。
+		v = id + keyIDSep + v
+	}
+	return v, nil
 }
 
-// decodeSession 从cookie值中解码 [Session] 。
-func (c *Control) decodeSession(v string) (bool, Session) {
+// decodeSession 从名为name的cookie的值中解码 [Session] 。
+func (c *Control) decodeSession(name, v string) (bool, Session) {
+	// 分离密钥ID。
+	id, v, ok := strings.Cut(v, keyIDSep)
+	if !ok {
+		id, v = "", id
+	}
 	// 恢复成密文。
+	// 不论是否设置了Base64Cookie，都接受两种编码，以便切换时不使用户退出登录。
+	// base64编码的密文的每个字符都在base32的字母表中的概率可以忽略不计，
+	// 所以先尝试base32。
 	b, err := base32.StdEncoding.DecodeString(v)
 	if err != nil {
-		return false, Session{}
+		b, err = base64.RawURLEncoding.DecodeString(v)
+		if err != nil {
+			return false, Session{}
+		}
 	}
-	v = unsafe.String(unsafe.SliceData(b), len(b))
 	// 解密。
-	s := c.decrypt(v)
+	b, err = c.open(id, b, c.additionalData(name))
 	var se Session
-	if s == "" {
+	if err != nil {
 		return false, se
 	}
 	// 解码。
-	ok := se.decode(s)
+	ok = se.decode(b)
 	return ok, se
 }
 
@@ -107,12 +107,12 @@ func TestAll(t *testing.T) {
 	if cs[0].Name != "session" {
 		t.Fatalf("got %s, want session", cs[0].Name)
 	}
-	c.decodeSession(cs[0].Value)
+	c.decodeSession(cs[0].Name, cs[0].Value)
 	if logined, err, _ := c.CheckLogined("192.168.0.1", user_agent, cs[0]); !logined || err != nil {
 		t.Log(logined)
 		t.Fatal(err)
 	}
-	ok, s2 := c.decodeSession(cs[0].Value)
+	ok, s2 := c.decodeSession(cs[0].Name, cs[0].Value)
 	if !ok {
 		t.Fatalf("should success")
 	}
@@ -0,0 +1,106 @@
+package safesession
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"unsafe"
+)
+
+// Sealer 加解密 [Session] ，并使用附加数据认证密文。
+//
+// [Control] 使用cookie的name，domain，path作为附加数据，
+// 所以复制到其他name或path的cookie无法解密。
+//
+// 从多个goroutine调用里面的方法应该是安全的。
+type Sealer interface {
+	// Seal 加密plaintext，并将additionalData绑定到密文。
+	Seal(plaintext, additionalData []byte) ([]byte, error)
+	// Open 解密ciphertext，
+	// 如果密文被篡改或additionalData与加密时不同，返回错误。
+	Open(ciphertext, additionalData []byte) ([]byte, error)
+}
+
+var ErrDecrypt = errors.New("解密失败")
+
+// NewAESGCM 创建一个使用AES-GCM的 [Sealer] 。
+// key 的长度应该是32字节，以使用AES-256-GCM。
+// 每次加密使用随机的nonce，并放在密文开头。
+func NewAESGCM(key []byte) (Sealer, error) {
+	if len(key) != 32 {
+		return nil, errors.New("AES-256-GCM的密钥长度应该是32字节")
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return aesGCM{aead}, nil
+}
+
+type aesGCM struct {
+	aead cipher.AEAD
+}
+
+func (a aesGCM) Seal(plaintext, additionalData []byte) ([]byte, error) {
+	nonce := make([]byte, a.aead.NonceSize(), a.aead.NonceSize()+len(plaintext)+a.aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return a.aead.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+func (a aesGCM) Open(ciphertext, additionalData []byte) ([]byte, error) {
+	n := a.aead.NonceSize()
+	if len(ciphertext) < n+a.aead.Overhead() {
+		return nil, ErrDecrypt
+	}
+	b, err := a.aead.Open(nil, ciphertext[:n], ciphertext[n:], additionalData)
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	return b, nil
+}
+
+// FuncSealer 将加解密函数包装为 [Sealer] ，
+// 比如https://github.com/qiulaidongfeng/key的加解密函数。
+// 附加数据会被忽略，decrypt返回""表示解密失败。
+func FuncSealer(encrypt, decrypt func(string) string) Sealer {
+	return funcSealer{encrypt, decrypt}
+}
+
+type funcSealer struct {
+	encrypt, decrypt func(string) string
+}
+
+func (f funcSealer) Seal(plaintext, additionalData []byte) ([]byte, error) {
+	v := f.encrypt(unsafe.String(unsafe.SliceData(plaintext), len(plaintext)))
+	return []byte(v), nil
+}
+
+func (f funcSealer) Open(ciphertext, additionalData []byte) ([]byte, error) {
+	if f.decrypt == nil {
+		return nil, ErrDecrypt
+	}
+	v := f.decrypt(unsafe.String(unsafe.SliceData(ciphertext), len(ciphertext)))
+	if v == "" {
+		return nil, ErrDecrypt
+	}
+	return []byte(v), nil
+}
+
+// SetSealer 设置加解密没有密钥ID的cookie使用的 [Sealer] ，
+// 覆盖 [NewControl] 的encrypt,decrypt。
+// 不能与其他方法并发调用。
+func (c *Control) SetSealer(s Sealer) {
+	c.sealer = s
+}
+
+// additionalData 返回名为name的cookie的附加数据。
+func (c *Control) additionalData(name string) []byte {
+	return []byte(name + "\x00" + c.cookieDomain() + "\x00" + c.cookiePath())
+}
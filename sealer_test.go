@@ -0,0 +1,99 @@
+package safesession
+
+import (
+	"bytes"
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAESGCM(t *testing.T) {
+	s, err := NewAESGCM(bytes.Repeat([]byte{1}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := s.Seal([]byte("hello"), []byte("ad"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p, err := s.Open(c, []byte("ad")); err != nil || string(p) != "hello" {
+		t.Fatalf("got %q %v", p, err)
+	}
+	if _, err := s.Open(c, []byte("other")); err != ErrDecrypt {
+		t.Fatalf("got %v, want ErrDecrypt", err)
+	}
+	c[len(c)-1] ^= 1
+	if _, err := s.Open(c, []byte("ad")); err != ErrDecrypt {
+		t.Fatalf("got %v, want ErrDecrypt", err)
+	}
+	if _, err := s.Open(c[:3], []byte("ad")); err != ErrDecrypt {
+		t.Fatalf("got %v, want ErrDecrypt", err)
+	}
+	if _, err := NewAESGCM(make([]byte, 16)); err == nil {
+		t.Fatal("AES-128 key should fail")
+	}
+}
+
+func TestSealerAdditionalData(t *testing.T) {
+	Test = true
+	defer func() { Test = false }()
+	store := &flakyStore{m: make(map[string]time.Time)}
+	c := NewControlWithStore(nil, nil, time.Hour, 0, nil, store)
+	sealer, err := NewAESGCM(bytes.Repeat([]byte{2}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.SetSealer(sealer)
+	s, err := c.NewSessionContext(context.Background(), "", user_agent, "ok")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	c.SetSession(&s, w)
+	cookie := w.Result().Cookies()[0]
+	if ok, _ := c.decodeSession(cookie.Name, cookie.Value); !ok {
+		t.Fatal("should decode")
+	}
+	if ok, _ := c.decodeSession("other", cookie.Value); ok {
+		t.Fatal("cookie copied to other name should not decode")
+	}
+	c.CookiePath = func() string { return "/admin" }
+	defer func() { c.CookiePath = nil }()
+	if ok, _ := c.decodeSession(cookie.Name, cookie.Value); ok {
+		t.Fatal("cookie for other path should not decode")
+	}
+}
+
+func TestBase64Cookie(t *testing.T) {
+	key := make([]byte, 32)
+	sealer, err := NewAESGCM(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := NewControl(nil, nil, time.Hour, 0, nil, DB{})
+	c.SetSealer(sealer)
+	s := testSession
+	old, err := c.encodeSession("session", &s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Base64Cookie = true
+	v, err := c.encodeSession("session", &s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.ContainsAny(v, "=+/") || len(v) >= len(old) {
+		t.Fatalf("base64 %d bytes, base32 %d bytes: %s", len(v), len(old), v)
+	}
+	for _, v := range []string{old, v} {
+		if ok, se := c.decodeSession("session", v); !ok || se.ID != s.ID {
+			t.Fatalf("decode %s failed", v)
+		}
+	}
+	c.Base64Cookie = false
+	if ok, _ := c.decodeSession("session", v); !ok {
+		t.Fatal("should accept base64 cookie after switching back")
+	}
+}
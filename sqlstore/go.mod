@@ -0,0 +1,27 @@
+module github.com/qiulaidongfeng/safesession/v3/sqlstore
+
+go 1.24
+
+require (
+	github.com/qiulaidongfeng/safesession/v3 v3.1.0
+	modernc.org/sqlite v1.38.2
+)
+
+require (
+	github.com/dustin/go-humanize v1.0.1 // indirect
+	github.com/google/uuid v1.6.0 // indirect
+	github.com/mattn/go-isatty v0.0.20 // indirect
+	github.com/mileusna/useragent v1.3.5 // indirect
+	github.com/ncruces/go-strftime v0.1.9 // indirect
+	github.com/remyoudompheng/bigfft v0.0.0-20230129092748-24d4a6f8daec // indirect
+	golang.org/x/exp v0.0.0-20250620022241-b7579e27df2b // indirect
+	golang.org/x/sys v0.34.0 // indirect
+	modernc.org/libc v1.66.3 // indirect
+	modernc.org/mathutil v1.7.1 // indirect
+	modernc.org/memory v1.11.0 // indirect
+)
+
+// 与主模块一起开发，replace只对本模块生效。
+// 发布时主模块和本模块同时打标签（v3.1.0和sqlstore/v3.1.0），
+// 上面require的主模块版本必须包含本模块使用的API。
+replace github.com/qiulaidongfeng/safesession/v3 => ../
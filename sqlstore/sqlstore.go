@@ -0,0 +1,579 @@
+// Package sqlstore 实现使用 [database/sql] 保存 [safesession.Session] 的数据库。
+//
+// 保存验证 [safesession.Session] 本身有效的必要信息（ID，最近一次登录时间），
+// 和用于显示已登录设备的 [safesession.DeviceSummary] ，
+// 表结构为
+//
+//	id          VARCHAR(64)   PRIMARY KEY
+//	create_time BIGINT        -- Unix纳秒时间戳，有索引
+//	user_name   VARCHAR(255)  -- 有索引
+//	device      VARCHAR(2048) -- JSON编码的设备摘要
+//	evicted     SMALLINT      -- 1表示因为超过数量限制被删除
+//	issued_at   BIGINT        -- 首次登录时间的Unix纳秒时间戳，升级前创建的行为0
+//	rotated_to  VARCHAR(64)   -- 宽限期内的旧ID更换后的新ID
+//
+// 使用 [Store.Migrate] 创建或升级表结构。
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/qiulaidongfeng/safesession/v3"
+)
+
+// Dialect 是不同数据库的SQL方言。
+type Dialect struct {
+	// Name 是数据库名称。
+	Name string
+	// Placeholder 返回第n个参数的占位符，n从1开始。
+	Placeholder func(n int) string
+	// InsertIgnore 是插入一行并在主键重复时忽略的SQL格式，
+	// 依次使用表名，列名，值的占位符格式化。
+	InsertIgnore string
+}
+
+func question(int) string { return "?" }
+
+func dollar(n int) string { return fmt.Sprintf("$%d", n) }
+
+var (
+	// SQLite 是SQLite的方言，要求SQLite版本至少为3.24。
+	SQLite = Dialect{Name: "sqlite", Placeholder: question, InsertIgnore: "INSERT INTO %s (%s) VALUES (%s) ON CONFLICT DO NOTHING"}
+	// PostgreSQL 是PostgreSQL的方言。
+	PostgreSQL = Dialect{Name: "postgres", Placeholder: dollar, InsertIgnore: "INSERT INTO %s (%s) VALUES (%s) ON CONFLICT DO NOTHING"}
+	// MySQL 是MySQL的方言。
+	MySQL = Dialect{Name: "mysql", Placeholder: question, InsertIgnore: "INSERT IGNORE INTO %s (%s) VALUES (%s)"}
+)
+
+// Store 使用 [database/sql] 保存 [safesession.Session] 。
+//
+// 零值无效，必须使用 [New] 初始化。
+// 从多个goroutine调用是安全的。
+type Store struct {
+	// Valid 验证 [safesession.Session] 表示的用户登录状态有效，
+	// 可以为nil。
+	Valid func(UserName string, SessionID string) error
+	// OnError 在 [safesession.DB] 的操作发生数据库错误时被调用，
+	// 可以为nil。
+	OnError func(err error)
+
+	db      *sql.DB
+	dialect Dialect
+	table   string
+	maxAge  time.Duration
+}
+
+// New 创建一个 [Store] 。
+// table 是表名，为空时使用"sessions"。
+// maxAge 应该与 [safesession.NewControl] 的sessionMaxAge相同。
+func New(db *sql.DB, d Dialect, table string, maxAge time.Duration) *Store {
+	if table == "" {
+		table = "sessions"
+	}
+	return &Store{db: db, dialect: d, table: table, maxAge: maxAge}
+}
+
+// migration 是一条升级表结构的SQL，%[1]s是表名。
+type migration struct {
+	sql string
+	// column 不为空时，如果表已经有这一列，跳过这条SQL。
+	// MySQL等数据库会隐式提交DDL，升级中途失败后表结构可能已经部分升级，
+	// 这使重新升级时不会因为表或列已经存在而失败。
+	column string
+}
+
+// migrations 是按顺序升级表结构的SQL，每个元素是一个版本。
+// 只能在末尾添加新版本。
+var migrations = [][]migration{
+	{
+		{"CREATE TABLE %[1]s (id VARCHAR(64) NOT NULL PRIMARY KEY, create_time BIGINT NOT NULL)", "id"},
+		{"CREATE INDEX %[1]s_create_time ON %[1]s (create_time)", ""},
+	},
+	{
+		{"ALTER TABLE %[1]s ADD COLUMN user_name VARCHAR(255) NOT NULL DEFAULT ''", "user_name"},
+		{"ALTER TABLE %[1]s ADD COLUMN device VARCHAR(2048) NOT NULL DEFAULT ''", "device"},
+		{"CREATE INDEX %[1]s_user_name ON %[1]s (user_name)", ""},
+	},
+	{
+		{"ALTER TABLE %[1]s ADD COLUMN evicted SMALLINT NOT NULL DEFAULT 0", "evicted"},
+	},
+	{
+		{"ALTER TABLE %[1]s ADD COLUMN issued_at BIGINT NOT NULL DEFAULT 0", "issued_at"},
+	},
+	{
+		{"ALTER TABLE %[1]s ADD COLUMN rotated_to VARCHAR(64) NOT NULL DEFAULT ''", "rotated_to"},
+	},
+}
+
+// Migrate 创建或升级表结构到最新版本。
+// 表结构的版本保存在名为表名加"_schema"的表。
+//
+// 每个版本在单独的事务中升级，完成后立即记录版本。
+// MySQL等数据库会隐式提交DDL，所以一个版本中途失败时不能回滚，
+// 重新调用Migrate会跳过已经存在的表和列，
+// 但是如果索引已经创建而版本没有记录，需要手动删除索引后再重新调用。
+func (s *Store) Migrate(ctx context.Context) error {
+	schema := s.table + "_schema"
+	if _, err := s.db.ExecContext(ctx, "CREATE TABLE IF NOT EXISTS "+schema+" (version BIGINT NOT NULL)"); err != nil {
+		return err
+	}
+	var version int
+	err := s.db.QueryRowContext(ctx, "SELECT version FROM "+schema).Scan(&version)
+	switch err {
+	case nil:
+	case sql.ErrNoRows:
+		if _, err := s.db.ExecContext(ctx, "INSERT INTO "+schema+" (version) VALUES (0)"); err != nil {
+			return err
+		}
+	default:
+		return err
+	}
+	if version > len(migrations) {
+		return fmt.Errorf("sqlstore: 表 %s 的版本 %d 比支持的版本 %d 更新", s.table, version, len(migrations))
+	}
+	for ; version < len(migrations); version++ {
+		if err := s.migrate(ctx, schema, version); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrate 从version升级到下一个版本。
+func (s *Store) migrate(ctx context.Context, schema string, version int) error {
+	// 在事务外检查列是否存在，
+	// 因为PostgreSQL在事务中的语句失败后会中止事务。
+	var todo []string
+	for _, m := range migrations[version] {
+		if m.column != "" && s.hasColumn(ctx, m.column) {
+			continue
+		}
+		todo = append(todo, fmt.Sprintf(m.sql, s.table))
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, q := range todo {
+		if _, err := tx.ExecContext(ctx, q); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.ExecContext(ctx, "UPDATE "+schema+" SET version = "+s.ph(1), version+1); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// hasColumn 报告表是否存在并且有名为column的列。
+func (s *Store) hasColumn(ctx context.Context, column string) bool {
+	rows, err := s.db.QueryContext(ctx, "SELECT "+column+" FROM "+s.table+" WHERE 1 = 0")
+	if err != nil {
+		return false
+	}
+	rows.Close()
+	return true
+}
+
+// DB 返回使用s的 [safesession.DB] 。
+//
+// 因为 [safesession.DB] 的操作不能返回错误，
+// 发生数据库错误时调用 [Store.OnError] ，
+// 并且Store返回true以免无限重试，Exist返回false。
+func (s *Store) DB() safesession.DB {
+	return safesession.DB{
+		Store: func(ID string, CreateTime time.Time) bool {
+			ok, err := s.store(context.Background(), ID, CreateTime)
+			if err != nil {
+				s.onError(err)
+				return true
+			}
+			return ok
+		},
+		Update: func(ID string, CreateTime time.Time) {
+			s.onError(s.update(context.Background(), ID, CreateTime))
+		},
+		Delete: func(ID string) {
+			s.onError(s.delete(context.Background(), ID))
+		},
+		Exist: func(ID string) bool {
+			ok, err := s.exist(context.Background(), ID)
+			s.onError(err)
+			return ok
+		},
+		Valid: func(UserName string, SessionID string) error {
+			if s.Valid != nil {
+				return s.Valid(UserName, SessionID)
+			}
+			return nil
+		},
+	}
+}
+
+func (s *Store) onError(err error) {
+	if err != nil && s.OnError != nil {
+		s.OnError(err)
+	}
+}
+
+// ph 返回第n个参数的占位符。
+func (s *Store) ph(n int) string {
+	return s.dialect.Placeholder(n)
+}
+
+func (s *Store) store(ctx context.Context, ID string, CreateTime time.Time) (bool, error) {
+	q := fmt.Sprintf(s.dialect.InsertIgnore, s.table, "id, create_time, issued_at", s.ph(1)+", "+s.ph(2)+", "+s.ph(3))
+	r, err := s.db.ExecContext(ctx, q, ID, CreateTime.UnixNano(), CreateTime.UnixNano())
+	if err != nil {
+		return false, err
+	}
+	n, err := r.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	if n == 0 {
+		// ID重复，但可能是还没有清除的过期的登录会话。
+		q := "DELETE FROM " + s.table + " WHERE id = " + s.ph(1) + " AND create_time <= " + s.ph(2)
+		r, err := s.db.ExecContext(ctx, q, ID, s.deadline())
+		if err != nil {
+			return false, err
+		}
+		if n, err := r.RowsAffected(); err != nil || n == 0 {
+			return false, err
+		}
+		return s.store(ctx, ID, CreateTime)
+	}
+	return true, nil
+}
+
+func (s *Store) update(ctx context.Context, ID string, CreateTime time.Time) error {
+	q := "UPDATE " + s.table + " SET create_time = " + s.ph(1) + " WHERE id = " + s.ph(2) + " AND rotated_to = ''"
+	_, err := s.db.ExecContext(ctx, q, CreateTime.UnixNano(), ID)
+	return err
+}
+
+func (s *Store) delete(ctx context.Context, ID string) error {
+	_, err := s.db.ExecContext(ctx, "DELETE FROM "+s.table+" WHERE id = "+s.ph(1), ID)
+	return err
+}
+
+func (s *Store) exist(ctx context.Context, ID string) (bool, error) {
+	q := "SELECT 1 FROM " + s.table + " WHERE id = " + s.ph(1) + " AND create_time > " + s.ph(2) + " AND evicted = 0"
+	var one int
+	err := s.db.QueryRowContext(ctx, q, ID, s.deadline()).Scan(&one)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (s *Store) saveDevice(ctx context.Context, d safesession.DeviceSummary) error {
+	b, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+	q := "UPDATE " + s.table + " SET user_name = " + s.ph(1) + ", device = " + s.ph(2) + " WHERE id = " + s.ph(3)
+	_, err = s.db.ExecContext(ctx, q, d.UserName, string(b), d.ID)
+	return err
+}
+
+func (s *Store) devices(ctx context.Context, UserName string) ([]safesession.DeviceSummary, error) {
+	q := "SELECT id, create_time, device FROM " + s.table + " WHERE user_name = " + s.ph(1) + " AND create_time > " + s.ph(2) + " AND evicted = 0 AND rotated_to = ''"
+	rows, err := s.db.QueryContext(ctx, q, UserName, s.deadline())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ret []safesession.DeviceSummary
+	for rows.Next() {
+		var d safesession.DeviceSummary
+		var id, device string
+		var t int64
+		if err := rows.Scan(&id, &t, &device); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(device), &d); err != nil {
+			return nil, err
+		}
+		d.ID, d.UserName, d.CreateTime = id, UserName, time.Unix(0, t)
+		ret = append(ret, d)
+	}
+	return ret, rows.Err()
+}
+
+// storeLimited 在一个可串行化的事务中检查数量限制并插入登录会话。
+// 被挤下线的登录会话标记为evicted，过期后由 [Store.Purge] 清除。
+// 并发冲突时数据库可能返回序列化失败的错误。
+func (s *Store) storeLimited(ctx context.Context, ID, UserName string, CreateTime time.Time, max int, limit safesession.SessionLimit) (bool, error) {
+	tx, err := s.db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelSerializable})
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+	deadline := s.deadline()
+	q := "SELECT id FROM " + s.table + " WHERE user_name = " + s.ph(1) + " AND create_time > " + s.ph(2) + " AND evicted = 0 AND rotated_to = '' ORDER BY create_time"
+	rows, err := tx.QueryContext(ctx, q, UserName, deadline)
+	if err != nil {
+		return false, err
+	}
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return false, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return false, err
+	}
+	if len(ids) >= max {
+		if limit == safesession.RejectNew {
+			return false, safesession.ErrTooManySessions
+		}
+		for _, id := range ids[:len(ids)-max+1] {
+			if _, err := tx.ExecContext(ctx, "UPDATE "+s.table+" SET evicted = 1 WHERE id = "+s.ph(1), id); err != nil {
+				return false, err
+			}
+		}
+	}
+	// ID重复，但可能是还没有清除的过期的登录会话。
+	q = "DELETE FROM " + s.table + " WHERE id = " + s.ph(1) + " AND create_time <= " + s.ph(2)
+	if _, err := tx.ExecContext(ctx, q, ID, deadline); err != nil {
+		return false, err
+	}
+	q = fmt.Sprintf(s.dialect.InsertIgnore, s.table, "id, create_time, issued_at, user_name", s.ph(1)+", "+s.ph(2)+", "+s.ph(3)+", "+s.ph(4))
+	r, err := tx.ExecContext(ctx, q, ID, CreateTime.UnixNano(), CreateTime.UnixNano(), UserName)
+	if err != nil {
+		return false, err
+	}
+	n, err := r.RowsAffected()
+	if err != nil || n == 0 {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// rotate 在一个可串行化的事务中更换登录会话ID。
+// 宽限期内的旧ID记录新ID，并修改最近一次登录时间使它在grace后过期。
+func (s *Store) rotate(ctx context.Context, oldID, newID string, CreateTime time.Time, grace time.Duration) (string, error) {
+	tx, err := s.db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelSerializable})
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+	deadline := s.deadline()
+	var createTime, issuedAt int64
+	var userName, device, rotatedTo string
+	q := "SELECT create_time, issued_at, user_name, device, rotated_to FROM " + s.table + " WHERE id = " + s.ph(1) + " AND create_time > " + s.ph(2) + " AND evicted = 0"
+	err = tx.QueryRowContext(ctx, q, oldID, deadline).Scan(&createTime, &issuedAt, &userName, &device, &rotatedTo)
+	if err == sql.ErrNoRows {
+		return "", safesession.NotLogined
+	}
+	if err != nil {
+		return "", err
+	}
+	if rotatedTo != "" {
+		// 并发的请求在宽限期内更换过ID。
+		q := "UPDATE " + s.table + " SET create_time = " + s.ph(1) + " WHERE id = " + s.ph(2) + " AND create_time > " + s.ph(3)
+		r, err := tx.ExecContext(ctx, q, CreateTime.UnixNano(), rotatedTo, deadline)
+		if err != nil {
+			return "", err
+		}
+		if n, err := r.RowsAffected(); err != nil || n == 0 {
+			if err == nil {
+				err = safesession.NotLogined
+			}
+			return "", err
+		}
+		return rotatedTo, tx.Commit()
+	}
+	// ID重复，但可能是还没有清除的过期的登录会话。
+	q = "DELETE FROM " + s.table + " WHERE id = " + s.ph(1) + " AND create_time <= " + s.ph(2)
+	if _, err := tx.ExecContext(ctx, q, newID, deadline); err != nil {
+		return "", err
+	}
+	q = fmt.Sprintf(s.dialect.InsertIgnore, s.table, "id, create_time, issued_at, user_name, device", s.ph(1)+", "+s.ph(2)+", "+s.ph(3)+", "+s.ph(4)+", "+s.ph(5))
+	r, err := tx.ExecContext(ctx, q, newID, CreateTime.UnixNano(), issuedAt, userName, device)
+	if err != nil {
+		return "", err
+	}
+	if n, err := r.RowsAffected(); err != nil || n == 0 {
+		return "", err
+	}
+	if grace <= 0 {
+		_, err = tx.ExecContext(ctx, "DELETE FROM "+s.table+" WHERE id = "+s.ph(1), oldID)
+	} else {
+		// 使旧ID在grace后过期。
+		createTime = min(createTime, time.Now().Add(grace-s.maxAge).UnixNano())
+		q := "UPDATE " + s.table + " SET rotated_to = " + s.ph(1) + ", create_time = " + s.ph(2) + " WHERE id = " + s.ph(3)
+		_, err = tx.ExecContext(ctx, q, newID, createTime, oldID)
+	}
+	if err != nil {
+		return "", err
+	}
+	return newID, tx.Commit()
+}
+
+func (s *Store) issuedAt(ctx context.Context, ID string) (time.Time, error) {
+	var t int64
+	err := s.db.QueryRowContext(ctx, "SELECT issued_at FROM "+s.table+" WHERE id = "+s.ph(1), ID).Scan(&t)
+	if err == sql.ErrNoRows || err == nil && t == 0 {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(0, t), nil
+}
+
+func (s *Store) setIssuedAt(ctx context.Context, ID string, t time.Time) error {
+	q := "UPDATE " + s.table + " SET issued_at = " + s.ph(1) + " WHERE id = " + s.ph(2) + " AND (issued_at = 0 OR issued_at > " + s.ph(3) + ")"
+	_, err := s.db.ExecContext(ctx, q, t.UnixNano(), ID, t.UnixNano())
+	return err
+}
+
+func (s *Store) evicted(ctx context.Context, ID string) (bool, error) {
+	q := "SELECT 1 FROM " + s.table + " WHERE id = " + s.ph(1) + " AND create_time > " + s.ph(2) + " AND evicted = 1"
+	var one int
+	err := s.db.QueryRowContext(ctx, q, ID, s.deadline()).Scan(&one)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// deadline 返回最近一次登录时间不晚于它就过期的Unix纳秒时间戳。
+func (s *Store) deadline() int64 {
+	return time.Now().Add(-s.maxAge).UnixNano()
+}
+
+// Purge 分批清除过期的登录会话，每批最多batch行，返回清除的行数。
+// batch 小于等于0时使用1000。
+// 应该定期调用。
+func (s *Store) Purge(ctx context.Context, batch int) (int64, error) {
+	if batch <= 0 {
+		batch = 1000
+	}
+	deadline := s.deadline()
+	var total int64
+	for {
+		ids, err := s.expiredIDs(ctx, deadline, batch)
+		if err != nil {
+			return total, err
+		}
+		if len(ids) == 0 {
+			return total, nil
+		}
+		args := make([]any, 0, len(ids)+1)
+		ph := make([]string, 0, len(ids))
+		for i, id := range ids {
+			args = append(args, id)
+			ph = append(ph, s.ph(i+1))
+		}
+		args = append(args, deadline)
+		q := "DELETE FROM " + s.table + " WHERE id IN (" + strings.Join(ph, ", ") + ") AND create_time <= " + s.ph(len(ids)+1)
+		r, err := s.db.ExecContext(ctx, q, args...)
+		if err != nil {
+			return total, err
+		}
+		n, err := r.RowsAffected()
+		if err != nil {
+			return total, err
+		}
+		total += n
+		if len(ids) < batch {
+			return total, nil
+		}
+	}
+}
+
+// expiredIDs 返回最多n个过期的登录会话ID。
+func (s *Store) expiredIDs(ctx context.Context, deadline int64, n int) ([]string, error) {
+	q := fmt.Sprintf("SELECT id FROM %s WHERE create_time <= %s LIMIT %d", s.table, s.ph(1), n)
+	rows, err := s.db.QueryContext(ctx, q, deadline)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// ContextStore 返回使用s的 [safesession.Store] ，
+// 它同时实现了 [safesession.DeviceStore] ， [safesession.LimitStore] ，
+// [safesession.IssuedAtStore] 和 [safesession.RotateStore] ，
+// 数据库错误作为错误返回，不调用 [Store.OnError] 。
+func (s *Store) ContextStore() safesession.Store {
+	return ctxStore{s}
+}
+
+type ctxStore struct {
+	s *Store
+}
+
+func (c ctxStore) Store(ctx context.Context, ID string, CreateTime time.Time) (bool, error) {
+	return c.s.store(ctx, ID, CreateTime)
+}
+
+func (c ctxStore) Update(ctx context.Context, ID string, CreateTime time.Time) error {
+	return c.s.update(ctx, ID, CreateTime)
+}
+
+func (c ctxStore) Delete(ctx context.Context, ID string) error {
+	return c.s.delete(ctx, ID)
+}
+
+func (c ctxStore) Exist(ctx context.Context, ID string) (bool, error) {
+	return c.s.exist(ctx, ID)
+}
+
+func (c ctxStore) Valid(ctx context.Context, UserName string, SessionID string) error {
+	if c.s.Valid != nil {
+		return c.s.Valid(UserName, SessionID)
+	}
+	return nil
+}
+
+func (c ctxStore) SaveDevice(ctx context.Context, d safesession.DeviceSummary) error {
+	return c.s.saveDevice(ctx, d)
+}
+
+func (c ctxStore) Devices(ctx context.Context, UserName string) ([]safesession.DeviceSummary, error) {
+	return c.s.devices(ctx, UserName)
+}
+
+func (c ctxStore) StoreLimited(ctx context.Context, ID, UserName string, CreateTime time.Time, max int, limit safesession.SessionLimit) (bool, error) {
+	return c.s.storeLimited(ctx, ID, UserName, CreateTime, max, limit)
+}
+
+func (c ctxStore) Evicted(ctx context.Context, ID string) (bool, error) {
+	return c.s.evicted(ctx, ID)
+}
+
+func (c ctxStore) IssuedAt(ctx context.Context, ID string) (time.Time, error) {
+	return c.s.issuedAt(ctx, ID)
+}
+
+func (c ctxStore) Rotate(ctx context.Context, oldID, newID string, CreateTime time.Time, grace time.Duration) (string, error) {
+	return c.s.rotate(ctx, oldID, newID, CreateTime, grace)
+}
+
+func (c ctxStore) SetIssuedAt(ctx context.Context, ID string, t time.Time) error {
+	return c.s.setIssuedAt(ctx, ID, t)
+}
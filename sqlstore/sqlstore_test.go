@@ -0,0 +1,299 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/qiulaidongfeng/safesession/v3"
+	_ "modernc.org/sqlite"
+)
+
+func newStore(t *testing.T) *Store {
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	s := New(db, SQLite, "", time.Hour)
+	s.OnError = func(err error) { t.Fatal(err) }
+	if err := s.Migrate(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestMigrate(t *testing.T) {
+	s := newStore(t)
+	// 重复升级应该什么也不做。
+	if err := s.Migrate(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	var version int
+	if err := s.db.QueryRow("SELECT version FROM sessions_schema").Scan(&version); err != nil || version != len(migrations) {
+		t.Fatalf("got %d %v, want %d", version, err, len(migrations))
+	}
+	// 模拟MySQL隐式提交DDL后版本没有记录，重新升级应该跳过已经存在的列。
+	if _, err := s.db.Exec("UPDATE sessions_schema SET version = 2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Migrate(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStore(t *testing.T) {
+	db := newStore(t).DB()
+	now := time.Now()
+	if !db.Store("1", now) {
+		t.Fatal("should store")
+	}
+	if db.Store("1", now) {
+		t.Fatal("ID should be duplicate")
+	}
+	if !db.Exist("1") {
+		t.Fatal("should exist")
+	}
+	db.Update("1", now.Add(-2*time.Hour))
+	if db.Exist("1") {
+		t.Fatal("expired Session should not exist")
+	}
+	if !db.Store("1", now) {
+		t.Fatal("expired ID should be reusable")
+	}
+	db.Delete("1")
+	if db.Exist("1") {
+		t.Fatal("should not exist")
+	}
+}
+
+func TestPurge(t *testing.T) {
+	s := newStore(t)
+	db := s.DB()
+	for _, id := range []string{"1", "2", "3", "4", "5"} {
+		db.Store(id, time.Now().Add(-2*time.Hour))
+	}
+	db.Store("6", time.Now())
+	n, err := s.Purge(context.Background(), 2)
+	if err != nil || n != 5 {
+		t.Fatalf("got %d %v, want 5", n, err)
+	}
+	if !db.Exist("6") {
+		t.Fatal("should exist")
+	}
+}
+
+func TestPlaceholder(t *testing.T) {
+	if got := PostgreSQL.Placeholder(2); got != "$2" {
+		t.Fatalf("got %s, want $2", got)
+	}
+	if got := MySQL.Placeholder(2); got != "?" {
+		t.Fatalf("got %s, want ?", got)
+	}
+}
+
+func TestContextStore(t *testing.T) {
+	s := newStore(t)
+	cs := s.ContextStore()
+	ctx := context.Background()
+	if ok, err := cs.Store(ctx, "1", time.Now()); !ok || err != nil {
+		t.Fatal(ok, err)
+	}
+	if ok, err := cs.Exist(ctx, "1"); !ok || err != nil {
+		t.Fatal(ok, err)
+	}
+	s.db.Close()
+	if _, err := cs.Exist(ctx, "1"); err == nil {
+		t.Fatal("should return error")
+	}
+}
+
+func TestDevices(t *testing.T) {
+	safesession.Test = true
+	defer func() { safesession.Test = false }()
+	s := newStore(t)
+	c := safesession.NewControlWithStore(func(s string) string { return s }, func(s string) string { return s }, time.Hour, 0, nil, s.ContextStore())
+	ctx := context.Background()
+	ua := "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36"
+	var ids []string
+	for range 3 {
+		se, err := c.NewSessionContext(ctx, "", ua, "user")
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, se.ID)
+	}
+	if _, err := c.NewSessionContext(ctx, "", ua, "other"); err != nil {
+		t.Fatal(err)
+	}
+	list, err := c.ListSessionsContext(ctx, "user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 3 || list[0].Os != "Windows" || list[0].Browser != "Chrome" || list[0].CreateTime.IsZero() {
+		t.Fatalf("%+v", list)
+	}
+	if err := c.RevokeAllExceptContext(ctx, "user", ids[1]); err != nil {
+		t.Fatal(err)
+	}
+	list, err = c.ListSessionsContext(ctx, "user")
+	if err != nil || len(list) != 1 || list[0].ID != ids[1] {
+		t.Fatalf("%+v %v", list, err)
+	}
+	if list, err := c.ListSessionsContext(ctx, "other"); err != nil || len(list) != 1 {
+		t.Fatalf("%+v %v", list, err)
+	}
+}
+
+func TestSessionLimit(t *testing.T) {
+	safesession.Test = true
+	defer func() { safesession.Test = false }()
+	s := newStore(t)
+	c := safesession.NewControlWithStore(func(s string) string { return s }, func(s string) string { return s }, time.Hour, 0, nil, s.ContextStore())
+	c.MaxSessionsPerUser = 2
+	ctx := context.Background()
+	var cookies []*http.Cookie
+	for range 3 {
+		se, err := c.NewSessionContext(ctx, "", "", "user")
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := httptest.NewRecorder()
+		c.SetSession(&se, w)
+		cookies = append(cookies, w.Result().Cookies()[0])
+		time.Sleep(time.Millisecond)
+	}
+	if ok, err, _ := c.CheckLogined("", "", cookies[0]); ok || err != safesession.ErrEvicted {
+		t.Fatalf("got %v %v, want ErrEvicted", ok, err)
+	}
+	for _, cookie := range cookies[1:] {
+		if ok, err, _ := c.CheckLogined("", "", cookie); !ok {
+			t.Fatal(err)
+		}
+	}
+	c.SessionLimit = safesession.RejectNew
+	if _, err := c.NewSessionContext(ctx, "", "", "user"); err != safesession.ErrTooManySessions {
+		t.Fatalf("got %v, want ErrTooManySessions", err)
+	}
+	// NewSession不忽略SessionLimit，超过数量限制时panic。
+	func() {
+		defer func() {
+			if err := recover(); err != safesession.ErrTooManySessions {
+				t.Fatalf("got %v, want ErrTooManySessions", err)
+			}
+		}()
+		c.NewSession("", "", "user")
+	}()
+	c.SessionLimit = safesession.EvictOldest
+	c.NewSession("", "", "user")
+	if ok, err, _ := c.CheckLogined("", "", cookies[1]); ok || err != safesession.ErrEvicted {
+		t.Fatalf("got %v %v, want ErrEvicted", ok, err)
+	}
+	if _, err := c.NewSessionContext(ctx, "", "", "other"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestIssuedAt(t *testing.T) {
+	s := newStore(t)
+	cs := s.ContextStore().(safesession.IssuedAtStore)
+	ctx := context.Background()
+	issued := time.Unix(0, time.Now().UnixNano())
+	cs.Store(ctx, "1", issued)
+	cs.Update(ctx, "1", issued.Add(time.Minute))
+	if got, err := cs.IssuedAt(ctx, "1"); err != nil || !got.Equal(issued) {
+		t.Fatalf("got %v %v, want %v", got, err, issued)
+	}
+	if got, err := cs.IssuedAt(ctx, "2"); err != nil || !got.IsZero() {
+		t.Fatalf("got %v %v, want zero", got, err)
+	}
+	cs.SetIssuedAt(ctx, "1", time.Now())
+	if got, _ := cs.IssuedAt(ctx, "1"); !got.Equal(issued) {
+		t.Fatalf("got %v, SetIssuedAt should not change known IssuedAt", got)
+	}
+}
+
+func TestIssuedAtBackfill(t *testing.T) {
+	safesession.Test = true
+	defer func() { safesession.Test = false }()
+	s := newStore(t)
+	c := safesession.NewControlWithStore(func(s string) string { return s }, func(s string) string { return s }, time.Hour, 0, nil, s.ContextStore())
+	c.AbsoluteLifetime = time.Hour
+	ctx := context.Background()
+	se, err := c.NewSessionContext(ctx, "", "", "user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 模拟升级前创建的登录会话。
+	if _, err := s.db.Exec("UPDATE sessions SET issued_at = 0"); err != nil {
+		t.Fatal(err)
+	}
+	se.IssuedAt = time.Time{}
+	old := se
+	if ok, err := c.Check("", "", &se); !ok {
+		t.Fatal(err)
+	}
+	got, err := s.issuedAt(ctx, se.ID)
+	if err != nil || !got.Equal(time.Unix(0, se.IssuedAt.UnixNano())) {
+		t.Fatalf("got %v %v, want %v", got, err, se.IssuedAt)
+	}
+	// 不重新响应cookie时，使用保存的首次登录时间。
+	if ok, err := c.Check("", "", &old); !ok || !old.IssuedAt.Equal(got) {
+		t.Fatal(err, old.IssuedAt)
+	}
+}
+
+func TestRotate(t *testing.T) {
+	safesession.Test = true
+	defer func() { safesession.Test = false }()
+	s := newStore(t)
+	c := safesession.NewControlWithStore(func(s string) string { return s }, func(s string) string { return s }, time.Hour, 0, nil, s.ContextStore())
+	c.RotationInterval = time.Millisecond
+	ctx := context.Background()
+	se, err := c.NewSessionContext(ctx, "", "", "user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	old, oldID := se, se.ID
+	time.Sleep(2 * time.Millisecond)
+	if ok, err := c.Check("", "", &se); !ok {
+		t.Fatal(err)
+	}
+	if se.ID == oldID || !se.IssuedAt.Equal(old.IssuedAt) {
+		t.Fatalf("got %s, want new ID", se.ID)
+	}
+	// 宽限期内携带旧ID的并发请求得到相同的新ID。
+	if ok, err := c.Check("", "", &old); !ok || old.ID != se.ID {
+		t.Fatal(err, old.ID)
+	}
+	list, err := c.ListSessionsContext(ctx, "user")
+	if err != nil || len(list) != 1 || list[0].ID != se.ID {
+		t.Fatalf("%+v %v", list, err)
+	}
+	if err := c.Rotate(&se, httptest.NewRecorder()); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := s.ContextStore().Exist(ctx, oldID); !ok {
+		t.Fatal("rotated ID should exist in grace period")
+	}
+	if ok, _ := s.ContextStore().Exist(ctx, list[0].ID); ok {
+		t.Fatal("Rotate should delete old ID")
+	}
+
+	cs := s.ContextStore().(safesession.RotateStore)
+	cs.Store(ctx, "1", time.Now())
+	if id, err := cs.Rotate(ctx, "1", "2", time.Now(), time.Millisecond); id != "2" || err != nil {
+		t.Fatal(id, err)
+	}
+	time.Sleep(2 * time.Millisecond)
+	if ok, _ := cs.Exist(ctx, "1"); ok {
+		t.Fatal("rotated ID should expire after grace period")
+	}
+	if _, err := cs.Rotate(ctx, "1", "3", time.Now(), 0); err != safesession.NotLogined {
+		t.Fatal(err)
+	}
+}
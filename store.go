@@ -0,0 +1,71 @@
+package safesession
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var ErrStorageUnavailable = errors.New("登录会话存储暂时不可用，请稍后重试")
+
+// Store 包含需要的数据库操作。
+//
+// 与 [DB] 不同，每个操作都接受context并可以返回错误。
+// 返回的错误会被包装为 [ErrStorageUnavailable] ，不会使登录会话失效。
+// Valid返回的错误表示用户登录状态无效，会使登录会话失效，
+// 除非它包装了 [ErrStorageUnavailable] 。
+//
+// 从多个goroutine调用里面的方法应该是安全的。
+type Store interface {
+	// Store 存储验证 [Session] 本身有效的必要信息到数据库，
+	// 返回false表示ID重复。
+	Store(ctx context.Context, ID string, CreateTime time.Time) (bool, error)
+	// Update 更新验证 [Session] 本身有效的必要信息到数据库。
+	Update(ctx context.Context, ID string, CreateTime time.Time) error
+	// Delete 从数据库删除 [Session] 。
+	Delete(ctx context.Context, ID string) error
+	// Exist 查询是否有指定的 [Session] 。
+	Exist(ctx context.Context, ID string) (bool, error)
+	// Valid 验证 [Session] 表示的用户登录状态有效。
+	Valid(ctx context.Context, UserName string, SessionID string) error
+}
+
+// FromDB 将 [DB] 包装为 [Store] ，所有操作都不会返回存储错误。
+func FromDB(db DB) Store {
+	return dbStore{db}
+}
+
+type dbStore struct {
+	db DB
+}
+
+func (s dbStore) Store(ctx context.Context, ID string, CreateTime time.Time) (bool, error) {
+	return s.db.Store(ID, CreateTime), nil
+}
+
+func (s dbStore) Update(ctx context.Context, ID string, CreateTime time.Time) error {
+	s.db.Update(ID, CreateTime)
+	return nil
+}
+
+func (s dbStore) Delete(ctx context.Context, ID string) error {
+	s.db.Delete(ID)
+	return nil
+}
+
+func (s dbStore) Exist(ctx context.Context, ID string) (bool, error) {
+	return s.db.Exist(ID), nil
+}
+
+func (s dbStore) Valid(ctx context.Context, UserName string, SessionID string) error {
+	return s.db.Valid(UserName, SessionID)
+}
+
+// storageErr 将存储错误包装为 [ErrStorageUnavailable] 。
+func storageErr(err error) error {
+	if err == nil || errors.Is(err, ErrStorageUnavailable) {
+		return err
+	}
+	return fmt.Errorf("%w: %w", ErrStorageUnavailable, err)
+}
@@ -0,0 +1,98 @@
+package safesession
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+var errDown = errors.New("down")
+
+// flakyStore 是可以模拟数据库不可用的 [Store] 。
+type flakyStore struct {
+	m       map[string]time.Time
+	down    bool
+	deleted int
+}
+
+func (s *flakyStore) Store(ctx context.Context, ID string, CreateTime time.Time) (bool, error) {
+	if s.down {
+		return false, errDown
+	}
+	s.m[ID] = CreateTime
+	return true, nil
+}
+
+func (s *flakyStore) Update(ctx context.Context, ID string, CreateTime time.Time) error {
+	if s.down {
+		return errDown
+	}
+	s.m[ID] = CreateTime
+	return nil
+}
+
+func (s *flakyStore) Delete(ctx context.Context, ID string) error {
+	if s.down {
+		return errDown
+	}
+	s.deleted++
+	delete(s.m, ID)
+	return nil
+}
+
+func (s *flakyStore) Exist(ctx context.Context, ID string) (bool, error) {
+	if s.down {
+		return false, errDown
+	}
+	_, ok := s.m[ID]
+	return ok, nil
+}
+
+func (s *flakyStore) Valid(ctx context.Context, UserName string, SessionID string) error {
+	return nil
+}
+
+func TestStorageUnavailable(t *testing.T) {
+	Test = true
+	defer func() { Test = false }()
+	store := &flakyStore{m: make(map[string]time.Time)}
+	c := NewControlWithStore(func(s string) string { return s }, func(s string) string { return s }, time.Hour, 0, nil, store)
+	s, err := c.NewSessionContext(context.Background(), "", user_agent, "ok")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	c.SetSession(&s, w)
+	cookie := w.Result().Cookies()[0]
+
+	store.down = true
+	if _, err := c.NewSessionContext(context.Background(), "", user_agent, "ok"); !errors.Is(err, ErrStorageUnavailable) || !errors.Is(err, errDown) {
+		t.Fatal(err)
+	}
+	if ok, err, _ := c.CheckLogined("", user_agent, cookie); ok || !errors.Is(err, ErrStorageUnavailable) {
+		t.Fatal(ok, err)
+	}
+	if ok, err := c.Check("", "", &s); ok || !errors.Is(err, ErrStorageUnavailable) {
+		t.Fatal(ok, err)
+	}
+	h := c.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("User-Agent", user_agent)
+	r.AddCookie(cookie)
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusServiceUnavailable || len(w.Result().Cookies()) != 0 {
+		t.Fatalf("got %d %v", w.Code, w.Result().Cookies())
+	}
+
+	store.down = false
+	if store.deleted != 0 {
+		t.Fatal("should not delete Session when storage is unavailable")
+	}
+	if ok, err, _ := c.CheckLogined("", user_agent, cookie); !ok {
+		t.Fatal(err)
+	}
+}
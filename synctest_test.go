@@ -31,3 +31,40 @@ func TestExpire(t *testing.T) {
 		delete_num = 0
 	})
 }
+
+func TestAbsoluteLifetime(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		defer func(n int) { delete_num = n }(delete_num)
+		original := c.CheckIPInfo
+		c.CheckIPInfo = nil
+		defer func() { c.CheckIPInfo = original }()
+		c.AbsoluteLifetime = 24 * time.Hour
+		defer func() { c.AbsoluteLifetime = 0 }()
+		s := c.NewSession("192.168.0.1", user_agent, "ok")
+		if !s.IssuedAt.Equal(s.CreateTime) {
+			t.Fatal(s.IssuedAt, s.CreateTime)
+		}
+		issued := s.IssuedAt
+		for range 2 {
+			time.Sleep(12*time.Hour - time.Second)
+			if _, err := c.Check("192.168.0.1", user_agent, &s); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if !s.IssuedAt.Equal(issued) {
+			t.Fatal("IssuedAt should not change")
+		}
+		time.Sleep(2 * time.Second)
+		if _, err := c.Check("192.168.0.1", user_agent, &s); err != ErrAbsoluteExpired {
+			t.Fatal(err)
+		}
+
+		// 升级前创建的登录会话从第一次检查开始计算。
+		s = c.NewSession("192.168.0.1", user_agent, "ok")
+		s.IssuedAt = time.Time{}
+		time.Sleep(time.Hour)
+		if _, err := c.Check("192.168.0.1", user_agent, &s); err != nil || !s.IssuedAt.Equal(time.Now()) {
+			t.Fatal(err, s.IssuedAt)
+		}
+	})
+}